@@ -0,0 +1,32 @@
+// Package balancer 提供基于注册中心服务信息的 gRPC 负载均衡策略，
+// 导入本包即完成注册，客户端通过 DialOption 或服务配置选用
+package balancer
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+)
+
+const (
+	// WeightedRoundRobin 按 ServiceDesc.Weight 进行平滑加权轮询
+	WeightedRoundRobin = "titan_weighted_round_robin"
+	// LeastRequest 选择未完成请求数最少的实例
+	LeastRequest = "titan_least_request"
+)
+
+func init() {
+	balancer.Register(newWeightedBuilder())
+	balancer.Register(&leastRequestBuilder{})
+}
+
+// ServiceConfig 返回选用指定负载均衡策略的服务配置
+func ServiceConfig(name string) string {
+	return fmt.Sprintf(`{"loadBalancingConfig": [{"%s":{}}]}`, name)
+}
+
+// DialOption 返回选用指定负载均衡策略的拨号选项
+func DialOption(name string) grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(ServiceConfig(name))
+}
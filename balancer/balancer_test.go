@@ -0,0 +1,81 @@
+package balancer
+
+import (
+	"testing"
+
+	"github.com/dotnetage/go-titan/config"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	name string
+}
+
+func buildInfo(weights map[string]int64) (base.PickerBuildInfo, map[string]balancer.SubConn) {
+	info := base.PickerBuildInfo{ReadySCs: make(map[balancer.SubConn]base.SubConnInfo)}
+	scs := make(map[string]balancer.SubConn)
+	for name, weight := range weights {
+		sc := &fakeSubConn{name: name}
+		desc := &runtime.ServiceDesc{EndPoint: *config.NewEndpoint(name), Weight: weight}
+		info.ReadySCs[sc] = base.SubConnInfo{Address: runtime.NewAddress(desc)}
+		scs[name] = sc
+	}
+	return info, scs
+}
+
+func pickName(t *testing.T, p balancer.Picker) (string, balancer.PickResult) {
+	result, err := p.Pick(balancer.PickInfo{})
+	require.NoError(t, err)
+	return result.SubConn.(*fakeSubConn).name, result
+}
+
+func TestWeightedRoundRobin(t *testing.T) {
+	info, _ := buildInfo(map[string]int64{"a:1": 5, "b:1": 1, "c:1": 0})
+	p := (&weightedPickerBuilder{}).Build(info)
+
+	counts := make(map[string]int)
+	for i := 0; i < 70; i++ {
+		name, _ := pickName(t, p)
+		counts[name]++
+	}
+	require.Equal(t, map[string]int{"a:1": 50, "b:1": 10, "c:1": 10}, counts)
+
+	_, err := (&weightedPickerBuilder{}).Build(base.PickerBuildInfo{}).Pick(balancer.PickInfo{})
+	require.ErrorIs(t, err, balancer.ErrNoSubConnAvailable)
+}
+
+func TestLeastRequest(t *testing.T) {
+	pb := &leastRequestPickerBuilder{outstanding: make(map[balancer.SubConn]*int64)}
+	info, _ := buildInfo(map[string]int64{"a:1": 1, "b:1": 1})
+	p := pb.Build(info)
+
+	first, firstResult := pickName(t, p)
+	second, _ := pickName(t, p)
+	require.NotEqual(t, first, second)
+
+	// 第一个请求完成后，该实例的请求数最少
+	firstResult.Done(balancer.DoneInfo{})
+	next, _ := pickName(t, p)
+	require.Equal(t, first, next)
+
+	// 重建 picker 后保留未完成的请求数
+	p = pb.Build(info)
+	third, _ := pickName(t, p)
+	fourth, _ := pickName(t, p)
+	require.NotEqual(t, third, fourth)
+}
+
+func TestServiceConfig(t *testing.T) {
+	for _, name := range []string{WeightedRoundRobin, LeastRequest} {
+		require.NotNil(t, balancer.Get(name))
+		require.Contains(t, ServiceConfig(name), name)
+	}
+	require.Equal(t, int64(0), runtime.AddressWeight(resolver.Address{Addr: "a:1"}))
+	require.Equal(t, int64(3), runtime.AddressWeight(resolver.Address{Addr: "a:1", Metadata: int64(3)}))
+}
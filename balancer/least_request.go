@@ -0,0 +1,80 @@
+package balancer
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+// leastRequestBuilder 为每个连接创建独立的计数，避免不同连接之间互相影响
+type leastRequestBuilder struct{}
+
+func (*leastRequestBuilder) Name() string {
+	return LeastRequest
+}
+
+func (*leastRequestBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastRequestPickerBuilder{outstanding: make(map[balancer.SubConn]*int64)}
+	return base.NewBalancerBuilder(LeastRequest, pb, base.Config{HealthCheck: true}).Build(cc, opts)
+}
+
+type leastRequestPickerBuilder struct {
+	mu sync.Mutex
+	// outstanding 各实例未完成的请求数，在重建 picker 时保留
+	outstanding map[balancer.SubConn]*int64
+}
+
+func (b *leastRequestPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	counters := make(map[balancer.SubConn]*int64, len(info.ReadySCs))
+	p := &leastRequestPicker{}
+	for sc := range info.ReadySCs {
+		n, ok := b.outstanding[sc]
+		if !ok {
+			n = new(int64)
+		}
+		counters[sc] = n
+		p.subConns = append(p.subConns, &countedSubConn{subConn: sc, outstanding: n})
+	}
+	b.outstanding = counters
+	return p
+}
+
+type countedSubConn struct {
+	subConn     balancer.SubConn
+	outstanding *int64
+}
+
+type leastRequestPicker struct {
+	subConns []*countedSubConn
+	next     uint32
+}
+
+func (p *leastRequestPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	// 从轮转的起点开始查找，请求数相同时依次选择不同的实例
+	start := atomic.AddUint32(&p.next, 1)
+	n := uint32(len(p.subConns))
+	var best *countedSubConn
+	for i := uint32(0); i < n; i++ {
+		sc := p.subConns[(start+i)%n]
+		if best == nil || atomic.LoadInt64(sc.outstanding) < atomic.LoadInt64(best.outstanding) {
+			best = sc
+		}
+	}
+
+	atomic.AddInt64(best.outstanding, 1)
+	return balancer.PickResult{
+		SubConn: best.subConn,
+		Done: func(balancer.DoneInfo) {
+			atomic.AddInt64(best.outstanding, -1)
+		},
+	}, nil
+}
@@ -0,0 +1,62 @@
+package balancer
+
+import (
+	"sync"
+
+	"github.com/dotnetage/go-titan/runtime"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+)
+
+func newWeightedBuilder() balancer.Builder {
+	return base.NewBalancerBuilder(WeightedRoundRobin, &weightedPickerBuilder{}, base.Config{HealthCheck: true})
+}
+
+type weightedPickerBuilder struct{}
+
+func (*weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	p := &weightedPicker{}
+	for sc, sci := range info.ReadySCs {
+		// 未设置权重的实例按权重1处理
+		weight := runtime.AddressWeight(sci.Address)
+		if weight <= 0 {
+			weight = 1
+		}
+		p.subConns = append(p.subConns, &weightedSubConn{subConn: sc, weight: weight})
+		p.total += weight
+	}
+	return p
+}
+
+type weightedSubConn struct {
+	subConn balancer.SubConn
+	weight  int64
+	current int64
+}
+
+// weightedPicker 平滑加权轮询，权重高的实例被选中的次数更多且分布均匀
+type weightedPicker struct {
+	mu       sync.Mutex
+	subConns []*weightedSubConn
+	total    int64
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var best *weightedSubConn
+	for _, sc := range p.subConns {
+		sc.current += sc.weight
+		if best == nil || sc.current > best.current {
+			best = sc
+		}
+	}
+	best.current -= p.total
+	return balancer.PickResult{SubConn: best.subConn}, nil
+}
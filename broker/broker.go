@@ -1,5 +1,14 @@
 package broker
 
+import "errors"
+
+var (
+	// ErrNotConnected is returned when the broker is used before Connect
+	// or after Disconnect.
+	ErrNotConnected = errors.New("broker is not connected")
+)
+
+// Broker is an interface used for asynchronous messaging.
 type Broker interface {
 	Init(...Option) error
 	Options() Options
@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/dotnetage/go-titan/codec"
+)
+
+// HeaderContentType carries the content type of the message body
+const HeaderContentType = "Content-Type"
+
+var (
+	typeOfEvent = reflect.TypeOf((*Event)(nil)).Elem()
+	typeOfError = reflect.TypeOf((*error)(nil)).Elem()
+
+	// ErrUnknownContentType is returned when no codec is registered for the
+	// content type of a message
+	ErrUnknownContentType = errors.New("broker: unknown content type")
+)
+
+// PublishValue encodes v with the codec of the broker and publishes it,
+// the content type is written into the message header.
+func PublishValue(b Broker, topic string, v interface{}, opts ...PublishOption) error {
+	c := b.Options().Codec
+	if c == nil {
+		c = codec.JSON{}
+	}
+
+	body, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.Publish(topic, &Message{
+		Header: map[string]string{HeaderContentType: c.String()},
+		Body:   body,
+	}, opts...)
+}
+
+// Decode unmarshals the message body into v with the codec named by the
+// content type header, falling back to the given codec when the header is absent.
+func Decode(m *Message, v interface{}, fallback codec.Marshaler) error {
+	c := fallback
+	if ct, ok := m.Header[HeaderContentType]; ok && len(ct) > 0 {
+		if c, ok = codec.ByContentType(ct); !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownContentType, ct)
+		}
+	}
+	if c == nil {
+		c = codec.JSON{}
+	}
+	return c.Unmarshal(m.Body, v)
+}
+
+// SubscribeValue subscribes to the topic with a typed handler.
+// fn must have the signature func(Event, *T) error, the message body is
+// decoded into a new *T before fn is called. T can be a proto message.
+func SubscribeValue(b Broker, topic string, fn interface{}, opts ...SubscribeOption) (Subscriber, error) {
+	h, err := valueHandler(fn, b.Options().Codec)
+	if err != nil {
+		return nil, err
+	}
+	return b.Subscribe(topic, h, opts...)
+}
+
+func valueHandler(fn interface{}, fallback codec.Marshaler) (Handler, error) {
+	fv := reflect.ValueOf(fn)
+	if !fv.IsValid() || fv.Kind() != reflect.Func || fv.IsNil() {
+		return nil, fmt.Errorf("broker: handler must be func(broker.Event, *T) error, got %T", fn)
+	}
+	ft := fv.Type()
+
+	if ft.NumIn() != 2 || ft.In(0) != typeOfEvent || ft.In(1).Kind() != reflect.Ptr ||
+		ft.NumOut() != 1 || ft.Out(0) != typeOfError {
+		return nil, fmt.Errorf("broker: handler must be func(broker.Event, *T) error, got %s", ft)
+	}
+
+	valueType := ft.In(1).Elem()
+
+	return func(e Event) error {
+		v := reflect.New(valueType)
+		if err := Decode(e.Message(), v.Interface(), fallback); err != nil {
+			return err
+		}
+
+		out := fv.Call([]reflect.Value{reflect.ValueOf(&e).Elem(), v})
+		if err, ok := out[0].Interface().(error); ok {
+			return err
+		}
+		return nil
+	}, nil
+}
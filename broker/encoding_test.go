@@ -0,0 +1,96 @@
+package broker_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+	"github.com/dotnetage/go-titan/codec"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type orderCreated struct {
+	ID    string  `json:"id"`
+	Total float64 `json:"total"`
+}
+
+func TestPublishValue(t *testing.T) {
+	t.Run("JSON", func(t *testing.T) {
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var received *orderCreated
+		_, err := broker.SubscribeValue(b, "orders", func(e broker.Event, o *orderCreated) error {
+			received = o
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, broker.PublishValue(b, "orders", &orderCreated{ID: "42", Total: 12.5}))
+		require.Equal(t, &orderCreated{ID: "42", Total: 12.5}, received)
+	})
+
+	t.Run("Proto", func(t *testing.T) {
+		b := memory.NewBroker(broker.Codec(codec.Proto{}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			received    *structpb.Struct
+			contentType string
+		)
+		_, err := broker.SubscribeValue(b, "orders", func(e broker.Event, s *structpb.Struct) error {
+			received = s
+			contentType = e.Message().Header[broker.HeaderContentType]
+			return nil
+		})
+		require.NoError(t, err)
+
+		msg, err := structpb.NewStruct(map[string]interface{}{"id": "42"})
+		require.NoError(t, err)
+		require.NoError(t, broker.PublishValue(b, "orders", msg))
+		require.True(t, proto.Equal(msg, received))
+		require.Equal(t, "application/protobuf", contentType)
+	})
+
+	t.Run("UnknownContentType", func(t *testing.T) {
+		var failed error
+		b := memory.NewBroker(broker.ErrorHandler(func(e broker.Event) error {
+			failed = e.Error()
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := broker.SubscribeValue(b, "orders", func(e broker.Event, o *orderCreated) error {
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{
+			Header: map[string]string{broker.HeaderContentType: "text/csv"},
+		}))
+		require.True(t, errors.Is(failed, broker.ErrUnknownContentType))
+	})
+
+	t.Run("InvalidHandler", func(t *testing.T) {
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := broker.SubscribeValue(b, "orders", func(o orderCreated) {})
+		require.Error(t, err)
+
+		_, err = broker.SubscribeValue(b, "orders", nil)
+		require.Error(t, err)
+
+		var fn func(broker.Event, *orderCreated) error
+		_, err = broker.SubscribeValue(b, "orders", fn)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,480 @@
+// Package file implements a durable broker.Broker that appends every message
+// to a segmented log on disk and lets consumers resume from a stored offset.
+//
+// Subscribers sharing a broker.Queue name form a durable consumer: its offset
+// is persisted whenever a message is acknowledged, and failed or unacknowledged
+// messages are delivered again up to MaxDeliveries times. Subscribers without a
+// queue only see new messages, each of them once.
+package file
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// HeaderOffset carries the log offset of a delivered message
+const HeaderOffset = "X-Offset"
+
+var (
+	// ErrInvalidTopic is returned for topics that can not be mapped to a directory
+	ErrInvalidTopic = errors.New("file broker: invalid topic")
+	// ErrAckTimeout is reported to the ErrorHandler for a message given up
+	// after it was not acknowledged within AckWait
+	ErrAckTimeout = errors.New("file broker: message not acknowledged")
+	// ErrConsumerStarted is returned when StartAt is given for a consumer
+	// that already has running members
+	ErrConsumerStarted = errors.New("file broker: consumer already started")
+
+	errStopped = errors.New("file broker: consumer stopped")
+)
+
+type fileBroker struct {
+	opts broker.Options
+	sync.RWMutex
+	dir       string
+	connected bool
+	logs      map[string]*topicLog
+	groups    map[string]*consumerGroup
+}
+
+// consumerGroup delivers the messages of a topic, one at a time and in
+// order, to its members in turn.
+type consumerGroup struct {
+	key     string
+	topic   string
+	durable bool
+	path    string
+	broker  *fileBroker
+	log     *topicLog
+	reader  *logReader
+
+	mu      sync.Mutex
+	members []*fileSubscriber
+	next    int
+	exit    chan struct{}
+	done    chan struct{}
+}
+
+type fileSubscriber struct {
+	id      string
+	topic   string
+	opts    broker.SubscribeOptions
+	handler broker.Handler
+	group   *consumerGroup
+}
+
+type fileEvent struct {
+	topic   string
+	message *broker.Message
+	err     error
+	acked   chan struct{}
+	once    sync.Once
+}
+
+// NewBroker returns a file backed broker. The first address given through
+// broker.Addrs is used as the data directory.
+func NewBroker(opts ...broker.Option) broker.Broker {
+	return &fileBroker{
+		opts:   broker.NewOptions(opts...),
+		logs:   make(map[string]*topicLog),
+		groups: make(map[string]*consumerGroup),
+	}
+}
+
+func (f *fileBroker) Init(opts ...broker.Option) error {
+	for _, o := range opts {
+		o(&f.opts)
+	}
+	return nil
+}
+
+func (f *fileBroker) Options() broker.Options {
+	return f.opts
+}
+
+func (f *fileBroker) Address() string {
+	return f.dir
+}
+
+func (f *fileBroker) Connect() error {
+	f.Lock()
+	defer f.Unlock()
+
+	if f.connected {
+		return nil
+	}
+
+	f.dir = DefaultDir
+	if len(f.opts.Addrs) > 0 {
+		f.dir = f.opts.Addrs[0]
+	}
+	f.connected = true
+	return nil
+}
+
+func (f *fileBroker) Disconnect() error {
+	f.Lock()
+	if !f.connected {
+		f.Unlock()
+		return nil
+	}
+	f.connected = false
+	groups := f.groups
+	logs := f.logs
+	f.groups = make(map[string]*consumerGroup)
+	f.logs = make(map[string]*topicLog)
+	f.Unlock()
+
+	for _, g := range groups {
+		g.stop()
+		<-g.done
+	}
+
+	var lastErr error
+	for _, l := range logs {
+		if err := l.Close(); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+func (f *fileBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	l, err := f.topicLog(topic)
+	if err != nil {
+		return err
+	}
+	_, err = l.Append(m)
+	return err
+}
+
+func (f *fileBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	options := broker.NewSubscribeOptions(opts...)
+	if len(options.Queue) > 0 && !validName(options.Queue) {
+		return nil, ErrInvalidTopic
+	}
+
+	l, err := f.topicLog(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := &fileSubscriber{
+		id:      uuid.NewV4().String(),
+		topic:   topic,
+		opts:    options,
+		handler: h,
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if !f.connected {
+		return nil, broker.ErrNotConnected
+	}
+
+	key := topic + "\x00" + sub.id
+	if len(options.Queue) > 0 {
+		key = topic + "\x00" + options.Queue
+	}
+
+	if g, ok := f.groups[key]; ok {
+		// 消费者已在读取，无法再改变其位置
+		if _, ok := startAt(options); ok {
+			return nil, ErrConsumerStarted
+		}
+		g.add(sub)
+		return sub, nil
+	}
+
+	g := &consumerGroup{
+		key:     key,
+		topic:   topic,
+		durable: len(options.Queue) > 0,
+		broker:  f,
+		log:     l,
+		exit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	start, _ := l.Next()
+	if g.durable {
+		g.path = filepath.Join(l.dir, url.PathEscape(options.Queue)+offsetExt)
+		stored, ok, err := readOffset(g.path)
+		if err != nil {
+			return nil, err
+		}
+		start = 0
+		if ok {
+			start = stored
+		}
+	}
+	if offset, ok := startAt(options); ok {
+		start = offset
+	}
+
+	g.reader = newLogReader(l, start)
+	g.add(sub)
+	f.groups[key] = g
+
+	go g.run()
+
+	return sub, nil
+}
+
+// topicLog returns the log of the topic, opening it on first use
+func (f *fileBroker) topicLog(topic string) (*topicLog, error) {
+	if !validName(topic) {
+		return nil, ErrInvalidTopic
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	if !f.connected {
+		return nil, broker.ErrNotConnected
+	}
+
+	if l, ok := f.logs[topic]; ok {
+		return l, nil
+	}
+
+	l, err := openLog(filepath.Join(f.dir, url.PathEscape(topic)), segmentSize(f.opts), syncWrites(f.opts))
+	if err != nil {
+		return nil, err
+	}
+	f.logs[topic] = l
+	return l, nil
+}
+
+func (f *fileBroker) unsubscribe(sub *fileSubscriber) {
+	g := sub.group
+	if g.remove(sub) > 0 {
+		return
+	}
+
+	f.Lock()
+	if f.groups[g.key] == g {
+		delete(f.groups, g.key)
+	}
+	f.Unlock()
+
+	g.stop()
+}
+
+func (g *consumerGroup) add(sub *fileSubscriber) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sub.group = g
+	g.members = append(g.members, sub)
+}
+
+// remove drops the subscriber and returns the number of remaining members
+func (g *consumerGroup) remove(sub *fileSubscriber) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for i, m := range g.members {
+		if m.id == sub.id {
+			g.members = append(g.members[:i:i], g.members[i+1:]...)
+			break
+		}
+	}
+	return len(g.members)
+}
+
+// pick returns the next member in round robin order
+func (g *consumerGroup) pick() *fileSubscriber {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.members) == 0 {
+		return nil
+	}
+	g.next = (g.next + 1) % len(g.members)
+	return g.members[g.next]
+}
+
+// stop signals the group to exit without waiting for it, so it is safe to
+// call from a handler. done is closed once the running delivery returns.
+func (g *consumerGroup) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	select {
+	case <-g.exit:
+	default:
+		close(g.exit)
+	}
+}
+
+func (g *consumerGroup) run() {
+	defer close(g.done)
+	defer g.reader.close()
+
+	for {
+		_, appended := g.log.Next()
+		offset, msg, err := g.reader.Read()
+		if err == io.EOF {
+			select {
+			case <-appended:
+				continue
+			case <-g.exit:
+				return
+			}
+		}
+		if errors.Is(err, errCorruptRecord) {
+			// 损坏的记录已被跳过，继续读取后续消息
+			g.reportError(msg, err)
+			continue
+		}
+		if err != nil {
+			g.reportError(msg, err)
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-g.exit:
+				return
+			}
+		}
+
+		if msg.Header == nil {
+			msg.Header = make(map[string]string)
+		}
+		msg.Header[HeaderOffset] = strconv.FormatInt(offset, 10)
+
+		if !g.process(msg) {
+			return
+		}
+
+		if g.durable {
+			if err := writeOffset(g.path, offset+1); err != nil {
+				g.reportError(msg, err)
+			}
+		}
+	}
+}
+
+// process delivers the message until it is acknowledged or given up, it
+// returns false once the group is stopped.
+//
+// A durable group delivers a message again after a handler error, with a
+// growing delay, or after AckWait, up to MaxDeliveries attempts. A message
+// that still fails is handed to the ErrorHandler and skipped. Non-durable
+// subscribers see every message once.
+func (g *consumerGroup) process(msg *broker.Message) bool {
+	for attempt := 1; ; attempt++ {
+		sub, err := g.deliver(msg)
+		if err == nil {
+			return true
+		}
+		if err == errStopped {
+			return false
+		}
+
+		if !g.durable || attempt >= maxDeliveries(sub.opts) {
+			g.reportError(msg, err)
+			return true
+		}
+
+		if err == ErrAckTimeout {
+			continue
+		}
+		select {
+		case <-time.After(redeliveryDelay(sub.opts, attempt)):
+		case <-g.exit:
+			return false
+		}
+	}
+}
+
+// deliver hands the message to one member. It returns nil once the message
+// is acknowledged, durable groups wait up to AckWait for it.
+func (g *consumerGroup) deliver(msg *broker.Message) (*fileSubscriber, error) {
+	sub := g.pick()
+	if sub == nil {
+		<-g.exit
+		return nil, errStopped
+	}
+
+	evt := &fileEvent{
+		topic:   g.topic,
+		message: msg,
+		acked:   make(chan struct{}),
+	}
+
+	if err := sub.handler(evt); err != nil {
+		return sub, err
+	}
+	if sub.opts.AutoAck {
+		_ = evt.Ack()
+	}
+	if !g.durable {
+		return sub, nil
+	}
+
+	timer := time.NewTimer(ackWait(sub.opts))
+	defer timer.Stop()
+
+	select {
+	case <-evt.acked:
+		return sub, nil
+	case <-timer.C:
+		return sub, ErrAckTimeout
+	case <-g.exit:
+		return sub, errStopped
+	}
+}
+
+func (g *consumerGroup) reportError(msg *broker.Message, err error) {
+	if eh := g.broker.opts.ErrorHandler; eh != nil {
+		_ = eh(&fileEvent{topic: g.topic, message: msg, err: err})
+	}
+}
+
+func (s *fileSubscriber) Options() broker.SubscribeOptions {
+	return s.opts
+}
+
+func (s *fileSubscriber) Topic() string {
+	return s.topic
+}
+
+// Unsubscribe removes the subscriber, a handler that is still running is not
+// waited for. It may be called from within the handler.
+func (s *fileSubscriber) Unsubscribe() error {
+	s.group.broker.unsubscribe(s)
+	return nil
+}
+
+func (e *fileEvent) Topic() string {
+	return e.topic
+}
+
+func (e *fileEvent) Message() *broker.Message {
+	return e.message
+}
+
+// Ack commits the message, the consumer offset is advanced and persisted
+func (e *fileEvent) Ack() error {
+	e.once.Do(func() {
+		if e.acked != nil {
+			close(e.acked)
+		}
+	})
+	return nil
+}
+
+func (e *fileEvent) Error() error {
+	return e.err
+}
+
+func validName(name string) bool {
+	return name != "" && name != "." && name != ".."
+}
@@ -0,0 +1,292 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	"github.com/stretchr/testify/require"
+)
+
+func publishN(t *testing.T, b broker.Broker, topic string, from, to int) {
+	for i := from; i < to; i++ {
+		require.NoError(t, b.Publish(topic, &broker.Message{
+			Header: map[string]string{"Seq": strconv.Itoa(i)},
+			Body:   []byte(fmt.Sprintf("message %d", i)),
+		}))
+	}
+}
+
+func receive(t *testing.T, ch <-chan broker.Event) broker.Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not delivered")
+		return nil
+	}
+}
+
+func TestFileBroker(t *testing.T) {
+	t.Run("ResumeFromStoredOffset", func(t *testing.T) {
+		dir := t.TempDir()
+
+		b := NewBroker(broker.Addrs(dir))
+		require.NoError(t, b.Connect())
+		publishN(t, b, "audit", 0, 5)
+
+		ch := make(chan broker.Event, 10)
+		_, err := b.Subscribe("audit", func(e broker.Event) error {
+			ch <- e
+			return nil
+		}, broker.Queue("auditor"), broker.DisableAutoAck())
+		require.NoError(t, err)
+
+		for i := 0; i < 3; i++ {
+			e := receive(t, ch)
+			require.Equal(t, strconv.Itoa(i), e.Message().Header["Seq"])
+			require.Equal(t, strconv.Itoa(i), e.Message().Header[HeaderOffset])
+			require.NoError(t, e.Ack())
+		}
+		// 等待偏移量落盘后模拟进程重启
+		<-time.After(50 * time.Millisecond)
+		require.NoError(t, b.Disconnect())
+
+		b = NewBroker(broker.Addrs(dir))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		publishN(t, b, "audit", 5, 6)
+
+		ch = make(chan broker.Event, 10)
+		_, err = b.Subscribe("audit", func(e broker.Event) error {
+			ch <- e
+			return nil
+		}, broker.Queue("auditor"))
+		require.NoError(t, err)
+
+		for i := 3; i < 6; i++ {
+			e := receive(t, ch)
+			require.Equal(t, fmt.Sprintf("message %d", i), string(e.Message().Body))
+		}
+	})
+
+	t.Run("RedeliverUnacked", func(t *testing.T) {
+		b := NewBroker(broker.Addrs(t.TempDir()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		ch := make(chan broker.Event, 10)
+		attempts := 0
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("temporary failure")
+			}
+			ch <- e
+			return nil
+		}, broker.Queue("billing"), AckWait(20*time.Millisecond))
+		require.NoError(t, err)
+
+		publishN(t, b, "orders", 0, 2)
+		require.Equal(t, "0", receive(t, ch).Message().Header["Seq"])
+		require.Equal(t, "1", receive(t, ch).Message().Header["Seq"])
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("GiveUpAfterMaxDeliveries", func(t *testing.T) {
+		failed := make(chan broker.Event, 1)
+		b := NewBroker(broker.Addrs(t.TempDir()), broker.ErrorHandler(func(e broker.Event) error {
+			failed <- e
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		ch := make(chan broker.Event, 10)
+		attempts := 0
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			if e.Message().Header["Seq"] == "0" {
+				attempts++
+				return errors.New("permanent failure")
+			}
+			ch <- e
+			return nil
+		}, broker.Queue("billing"), MaxDeliveries(3), RedeliveryDelay(time.Millisecond))
+		require.NoError(t, err)
+
+		publishN(t, b, "orders", 0, 2)
+		e := receive(t, failed)
+		require.Equal(t, "0", e.Message().Header["Seq"])
+		require.EqualError(t, e.Error(), "permanent failure")
+		require.Equal(t, "1", receive(t, ch).Message().Header["Seq"])
+		require.Equal(t, 3, attempts)
+	})
+
+	t.Run("NonDurableDoesNotRedeliver", func(t *testing.T) {
+		failed := make(chan broker.Event, 1)
+		b := NewBroker(broker.Addrs(t.TempDir()), broker.ErrorHandler(func(e broker.Event) error {
+			failed <- e
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		ch := make(chan broker.Event, 10)
+		_, err := b.Subscribe("events", func(e broker.Event) error {
+			ch <- e
+			return errors.New("failure")
+		})
+		require.NoError(t, err)
+
+		publishN(t, b, "events", 0, 2)
+		require.Equal(t, "0", receive(t, ch).Message().Header["Seq"])
+		require.Equal(t, "0", receive(t, failed).Message().Header["Seq"])
+		require.Equal(t, "1", receive(t, ch).Message().Header["Seq"])
+	})
+
+	t.Run("NonDurableSeesNewMessagesOnly", func(t *testing.T) {
+		b := NewBroker(broker.Addrs(t.TempDir()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		publishN(t, b, "events", 0, 3)
+
+		ch := make(chan broker.Event, 10)
+		_, err := b.Subscribe("events", func(e broker.Event) error {
+			ch <- e
+			return nil
+		})
+		require.NoError(t, err)
+
+		publishN(t, b, "events", 3, 4)
+		require.Equal(t, "3", receive(t, ch).Message().Header["Seq"])
+	})
+
+	t.Run("ReplayAcrossSegments", func(t *testing.T) {
+		dir := t.TempDir()
+		b := NewBroker(broker.Addrs(dir), SegmentSize(128))
+		require.NoError(t, b.Connect())
+		publishN(t, b, "ledger", 0, 20)
+		require.NoError(t, b.Disconnect())
+
+		segments, err := filepath.Glob(filepath.Join(dir, "ledger", "*"+segmentExt))
+		require.NoError(t, err)
+		require.Greater(t, len(segments), 1)
+
+		// 模拟写入一半时崩溃
+		last := segments[len(segments)-1]
+		f, err := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 0})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		b = NewBroker(broker.Addrs(dir), SegmentSize(128))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		publishN(t, b, "ledger", 20, 21)
+
+		ch := make(chan broker.Event, 30)
+		_, err = b.Subscribe("ledger", func(e broker.Event) error {
+			ch <- e
+			return nil
+		}, broker.Queue("replay"), StartAt(5))
+		require.NoError(t, err)
+
+		for i := 5; i < 21; i++ {
+			require.Equal(t, strconv.Itoa(i), receive(t, ch).Message().Header["Seq"])
+		}
+	})
+
+	t.Run("SkipCorruptRecord", func(t *testing.T) {
+		dir := t.TempDir()
+		b := NewBroker(broker.Addrs(dir), SegmentSize(128))
+		require.NoError(t, b.Connect())
+		publishN(t, b, "ledger", 0, 10)
+		require.NoError(t, b.Disconnect())
+
+		segments, err := filepath.Glob(filepath.Join(dir, "ledger", "*"+segmentExt))
+		require.NoError(t, err)
+		require.Greater(t, len(segments), 1)
+
+		// 破坏第一个分段中第一条记录的内容
+		data, err := ioutil.ReadFile(segments[0])
+		require.NoError(t, err)
+		data[recordHeaderSize] ^= 0xff
+		require.NoError(t, ioutil.WriteFile(segments[0], data, 0644))
+
+		failed := make(chan broker.Event, 1)
+		b = NewBroker(broker.Addrs(dir), SegmentSize(128), broker.ErrorHandler(func(e broker.Event) error {
+			failed <- e
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		ch := make(chan broker.Event, 10)
+		_, err = b.Subscribe("ledger", func(e broker.Event) error {
+			ch <- e
+			return nil
+		}, broker.Queue("replay"))
+		require.NoError(t, err)
+
+		require.ErrorIs(t, receive(t, failed).Error(), errCorruptRecord)
+		next, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(segments[1]), segmentExt), 10, 64)
+		require.NoError(t, err)
+		for i := next; i < 10; i++ {
+			require.Equal(t, strconv.FormatInt(i, 10), receive(t, ch).Message().Header["Seq"])
+		}
+	})
+
+	t.Run("UnsubscribeInHandler", func(t *testing.T) {
+		b := NewBroker(broker.Addrs(t.TempDir()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		done := make(chan struct{})
+		var sub broker.Subscriber
+		sub, err := b.Subscribe("jobs", func(e broker.Event) error {
+			require.NoError(t, sub.Unsubscribe())
+			close(done)
+			return nil
+		}, broker.Queue("worker"))
+		require.NoError(t, err)
+
+		publishN(t, b, "jobs", 0, 1)
+		select {
+		case <-done:
+		case <-time.After(2 * time.Second):
+			t.Fatal("unsubscribe blocked")
+		}
+	})
+
+	t.Run("StartAtExistingConsumer", func(t *testing.T) {
+		b := NewBroker(broker.Addrs(t.TempDir()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		handler := func(e broker.Event) error { return nil }
+		_, err := b.Subscribe("jobs", handler, broker.Queue("worker"))
+		require.NoError(t, err)
+		_, err = b.Subscribe("jobs", handler, broker.Queue("worker"), StartAt(0))
+		require.ErrorIs(t, err, ErrConsumerStarted)
+	})
+
+	t.Run("InvalidTopic", func(t *testing.T) {
+		b := NewBroker(broker.Addrs(t.TempDir()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		require.ErrorIs(t, b.Publish("..", &broker.Message{}), ErrInvalidTopic)
+
+		entries, err := ioutil.ReadDir(b.Address())
+		require.NoError(t, err)
+		require.Len(t, entries, 0)
+	})
+}
@@ -0,0 +1,374 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dotnetage/go-titan/broker"
+)
+
+const (
+	segmentExt = ".log"
+	offsetExt  = ".offset"
+	// recordHeaderSize offset(8) + length(4) + crc32(4)
+	recordHeaderSize = 16
+)
+
+var errCorruptRecord = errors.New("file broker: corrupt record")
+
+// topicLog is an append-only log of messages split into segment files.
+// Every segment is named after the offset of its first record.
+type topicLog struct {
+	sync.RWMutex
+	dir         string
+	segmentSize int64
+	fsync       bool
+
+	bases      []int64
+	active     *os.File
+	activeSize int64
+	next       int64
+	// appended is closed and replaced whenever a record is appended
+	appended chan struct{}
+}
+
+func openLog(dir string, segmentSize int64, fsync bool) (*topicLog, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	l := &topicLog{
+		dir:         dir,
+		segmentSize: segmentSize,
+		fsync:       fsync,
+		appended:    make(chan struct{}),
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		l.bases = append(l.bases, base)
+	}
+	sort.Slice(l.bases, func(i, j int) bool { return l.bases[i] < l.bases[j] })
+
+	if len(l.bases) == 0 {
+		return l, l.roll(0)
+	}
+
+	if err := l.recover(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover scans the last segment to find the next offset and drops a
+// partially written record left behind by a crash.
+func (l *topicLog) recover() error {
+	base := l.bases[len(l.bases)-1]
+	f, err := os.OpenFile(l.segmentPath(base), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	next, valid := base, int64(0)
+	br := bufio.NewReader(f)
+	for {
+		offset, _, n, err := readRecord(br)
+		if err != nil {
+			break
+		}
+		next = offset + 1
+		valid += n
+	}
+
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	l.active = f
+	l.activeSize = valid
+	l.next = next
+	return nil
+}
+
+func (l *topicLog) segmentPath(base int64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// roll starts a new segment whose first record has the given offset
+func (l *topicLog) roll(base int64) error {
+	if l.active != nil {
+		if err := l.active.Close(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.segmentPath(base), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	if len(l.bases) == 0 || l.bases[len(l.bases)-1] != base {
+		l.bases = append(l.bases, base)
+	}
+	l.active = f
+	l.activeSize = 0
+	l.next = base
+	return nil
+}
+
+// Append writes the message to the log and returns its offset
+func (l *topicLog) Append(m *broker.Message) (int64, error) {
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return 0, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.active == nil {
+		return 0, broker.ErrNotConnected
+	}
+
+	if l.activeSize > 0 && l.activeSize+int64(len(payload))+recordHeaderSize > l.segmentSize {
+		if err := l.roll(l.next); err != nil {
+			return 0, err
+		}
+	}
+
+	offset := l.next
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint64(record[0:8], uint64(offset))
+	binary.BigEndian.PutUint32(record[8:12], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[12:16], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	if _, err := l.active.Write(record); err != nil {
+		return 0, err
+	}
+	if l.fsync {
+		if err := l.active.Sync(); err != nil {
+			return 0, err
+		}
+	}
+
+	l.activeSize += int64(len(record))
+	l.next++
+	close(l.appended)
+	l.appended = make(chan struct{})
+
+	return offset, nil
+}
+
+// Next returns the offset the next appended record will get and a channel
+// that is closed once that happens.
+func (l *topicLog) Next() (int64, <-chan struct{}) {
+	l.RLock()
+	defer l.RUnlock()
+	return l.next, l.appended
+}
+
+func (l *topicLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.active == nil {
+		return nil
+	}
+	err := l.active.Close()
+	l.active = nil
+	return err
+}
+
+// segmentFor returns the base offset of the segment holding offset
+func (l *topicLog) segmentFor(offset int64) (int64, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	i := sort.Search(len(l.bases), func(i int) bool { return l.bases[i] > offset })
+	if i == 0 {
+		return 0, false
+	}
+	return l.bases[i-1], true
+}
+
+// segmentAfter returns the base offset of the segment following base
+func (l *topicLog) segmentAfter(base int64) (int64, bool) {
+	l.RLock()
+	defer l.RUnlock()
+
+	i := sort.Search(len(l.bases), func(i int) bool { return l.bases[i] > base })
+	if i == len(l.bases) {
+		return 0, false
+	}
+	return l.bases[i], true
+}
+
+// logReader reads committed records sequentially from a topic log
+type logReader struct {
+	log  *topicLog
+	base int64
+	f    *os.File
+	br   *bufio.Reader
+	next int64
+}
+
+func newLogReader(l *topicLog, offset int64) *logReader {
+	return &logReader{log: l, base: -1, next: offset}
+}
+
+// Read returns the record at the current position, or io.EOF when the
+// reader has caught up with the writer.
+func (r *logReader) Read() (int64, *broker.Message, error) {
+	for {
+		committed, _ := r.log.Next()
+		if r.next >= committed {
+			return 0, nil, io.EOF
+		}
+
+		if r.f == nil {
+			if err := r.open(); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		offset, payload, _, err := readRecord(r.br)
+		if err == io.EOF {
+			// 当前分段已读完，切换到下一个分段
+			r.close()
+			r.base = r.next
+			continue
+		}
+		if err == errCorruptRecord {
+			return 0, nil, r.skip(committed)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if offset < r.next {
+			continue
+		}
+
+		msg := &broker.Message{}
+		if err := json.Unmarshal(payload, msg); err != nil {
+			r.next = offset + 1
+			return 0, nil, fmt.Errorf("%w: offset %d: %v", errCorruptRecord, offset, err)
+		}
+		r.next = offset + 1
+		return offset, msg, nil
+	}
+}
+
+// skip moves past a corrupt record to the start of the next segment, the
+// rest of the current segment can not be split into records any more. In the
+// active segment it moves to the last committed offset.
+func (r *logReader) skip(committed int64) error {
+	from := r.next
+	to := committed
+	if base, ok := r.log.segmentAfter(r.base); ok && base < committed {
+		to = base
+	}
+
+	r.close()
+	r.base = to
+	r.next = to
+	return fmt.Errorf("%w: offsets %d-%d skipped", errCorruptRecord, from, to-1)
+}
+
+func (r *logReader) open() error {
+	base, ok := r.log.segmentFor(r.next)
+	if !ok {
+		return fmt.Errorf("file broker: offset %d not found", r.next)
+	}
+	if r.base >= 0 && base < r.base {
+		base = r.base
+	}
+	f, err := os.Open(r.log.segmentPath(base))
+	if err != nil {
+		return err
+	}
+	r.base = base
+	r.f = f
+	r.br = bufio.NewReader(f)
+	return nil
+}
+
+func (r *logReader) close() {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+		r.br = nil
+	}
+}
+
+func readRecord(br *bufio.Reader) (int64, []byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(br, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, 0, errCorruptRecord
+		}
+		return 0, nil, 0, err
+	}
+
+	offset := int64(binary.BigEndian.Uint64(header[0:8]))
+	size := binary.BigEndian.Uint32(header[8:12])
+	sum := binary.BigEndian.Uint32(header[12:16])
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return 0, nil, 0, errCorruptRecord
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return 0, nil, 0, errCorruptRecord
+	}
+	return offset, payload, int64(recordHeaderSize) + int64(size), nil
+}
+
+// readOffset loads the committed offset of a consumer
+func readOffset(path string) (int64, bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return offset, err == nil, err
+}
+
+// writeOffset atomically replaces the committed offset of a consumer
+func writeOffset(path string, offset int64) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
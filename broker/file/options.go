@@ -0,0 +1,122 @@
+package file
+
+import (
+	"context"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+)
+
+const (
+	// DefaultDir is used when no directory is given through broker.Addrs
+	DefaultDir = "./data/broker"
+	// DefaultSegmentSize is the size after which a new segment file is started
+	DefaultSegmentSize int64 = 64 << 20
+	// DefaultAckWait is how long an unacknowledged message waits before it is redelivered
+	DefaultAckWait = 30 * time.Second
+	// DefaultMaxDeliveries is how often a durable consumer tries a message before giving up
+	DefaultMaxDeliveries = 5
+	// DefaultRedeliveryDelay is the delay before a message is delivered again
+	// after a handler error, it doubles with every attempt up to AckWait
+	DefaultRedeliveryDelay = 100 * time.Millisecond
+)
+
+type segmentSizeKey struct{}
+type syncWritesKey struct{}
+type ackWaitKey struct{}
+type startAtKey struct{}
+type maxDeliveriesKey struct{}
+type redeliveryDelayKey struct{}
+
+// SegmentSize sets the maximum size in bytes of a log segment
+func SegmentSize(n int64) broker.Option {
+	return func(o *broker.Options) {
+		o.Context = context.WithValue(o.Context, segmentSizeKey{}, n)
+	}
+}
+
+// SyncWrites forces an fsync after every appended message
+func SyncWrites(b bool) broker.Option {
+	return func(o *broker.Options) {
+		o.Context = context.WithValue(o.Context, syncWritesKey{}, b)
+	}
+}
+
+// AckWait sets how long the broker waits for Event.Ack before the
+// message is delivered again
+func AckWait(d time.Duration) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, ackWaitKey{}, d)
+	}
+}
+
+// MaxDeliveries sets how often a durable consumer tries a message before it
+// is handed to the ErrorHandler and skipped
+func MaxDeliveries(n int) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, maxDeliveriesKey{}, n)
+	}
+}
+
+// RedeliveryDelay sets the delay before a message is delivered again after
+// a handler error, it doubles with every attempt up to AckWait
+func RedeliveryDelay(d time.Duration) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, redeliveryDelayKey{}, d)
+	}
+}
+
+// StartAt replays the topic from the given offset, ignoring the offset
+// stored for the consumer. Subscribe returns ErrConsumerStarted when the
+// consumer already has running members.
+func StartAt(offset int64) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, startAtKey{}, offset)
+	}
+}
+
+func segmentSize(o broker.Options) int64 {
+	if v, ok := o.Context.Value(segmentSizeKey{}).(int64); ok && v > 0 {
+		return v
+	}
+	return DefaultSegmentSize
+}
+
+func syncWrites(o broker.Options) bool {
+	v, _ := o.Context.Value(syncWritesKey{}).(bool)
+	return v
+}
+
+func ackWait(o broker.SubscribeOptions) time.Duration {
+	if v, ok := o.Context.Value(ackWaitKey{}).(time.Duration); ok && v > 0 {
+		return v
+	}
+	return DefaultAckWait
+}
+
+func startAt(o broker.SubscribeOptions) (int64, bool) {
+	v, ok := o.Context.Value(startAtKey{}).(int64)
+	return v, ok
+}
+
+func maxDeliveries(o broker.SubscribeOptions) int {
+	if v, ok := o.Context.Value(maxDeliveriesKey{}).(int); ok && v > 0 {
+		return v
+	}
+	return DefaultMaxDeliveries
+}
+
+func redeliveryDelay(o broker.SubscribeOptions, attempt int) time.Duration {
+	d := DefaultRedeliveryDelay
+	if v, ok := o.Context.Value(redeliveryDelayKey{}).(time.Duration); ok && v > 0 {
+		d = v
+	}
+	limit := ackWait(o)
+	for i := 1; i < attempt && d < limit; i++ {
+		d *= 2
+	}
+	if d > limit {
+		d = limit
+	}
+	return d
+}
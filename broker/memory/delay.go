@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type delayStoreKey struct{}
+
+// DelayStore persists messages published with broker.DeliverAt or
+// broker.DeliverAfter to the given file, so that pending deliveries
+// survive a restart. They are scheduled again on Connect.
+func DelayStore(path string) broker.Option {
+	return func(o *broker.Options) {
+		o.Context = context.WithValue(o.Context, delayStoreKey{}, path)
+	}
+}
+
+// delayed is a message waiting for its delivery time
+type delayed struct {
+	ID        string          `json:"id"`
+	Topic     string          `json:"topic"`
+	Message   *broker.Message `json:"message"`
+	DeliverAt time.Time       `json:"deliver_at"`
+}
+
+// scheduler holds back delayed messages until they are due
+type scheduler struct {
+	sync.Mutex
+	path    string
+	pending map[string]*delayed
+	timers  map[string]*time.Timer
+	running bool
+	deliver func(topic string, msg *broker.Message) error
+}
+
+func newScheduler(path string, deliver func(topic string, msg *broker.Message) error) *scheduler {
+	return &scheduler{
+		path:    path,
+		pending: make(map[string]*delayed),
+		timers:  make(map[string]*time.Timer),
+		deliver: deliver,
+	}
+}
+
+// start loads the persisted messages and arms a timer for each pending one
+func (s *scheduler) start() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.path) > 0 {
+		data, err := ioutil.ReadFile(s.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if len(data) > 0 {
+			var stored []*delayed
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+			for _, d := range stored {
+				s.pending[d.ID] = d
+			}
+		}
+	}
+
+	s.running = true
+	for _, d := range s.pending {
+		s.arm(d)
+	}
+	return nil
+}
+
+// stop disarms the timers, pending messages are kept for the next start
+func (s *scheduler) stop() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.running = false
+	for id, t := range s.timers {
+		t.Stop()
+		delete(s.timers, id)
+	}
+}
+
+func (s *scheduler) add(topic string, msg *broker.Message, at time.Time) error {
+	d := &delayed{
+		ID:        uuid.NewV4().String(),
+		Topic:     topic,
+		Message:   copyMessage(msg),
+		DeliverAt: at,
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.pending[d.ID] = d
+	if err := s.save(); err != nil {
+		delete(s.pending, d.ID)
+		return err
+	}
+	if s.running {
+		s.arm(d)
+	}
+	return nil
+}
+
+// arm must be called with the lock held
+func (s *scheduler) arm(d *delayed) {
+	s.timers[d.ID] = time.AfterFunc(time.Until(d.DeliverAt), func() {
+		s.fire(d.ID)
+	})
+}
+
+func (s *scheduler) fire(id string) {
+	s.Lock()
+	d, ok := s.pending[id]
+	if !ok || !s.running {
+		s.Unlock()
+		return
+	}
+	delete(s.timers, id)
+	s.Unlock()
+
+	// handler errors are treated like those of an immediate publish, but a
+	// message that fires while the broker is disconnected waits for Connect
+	if err := s.deliver(d.Topic, d.Message); err == broker.ErrNotConnected {
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	delete(s.pending, id)
+	_ = s.save()
+}
+
+// save writes the pending messages to the store, must be called with the lock held
+func (s *scheduler) save() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	stored := make([]*delayed, 0, len(s.pending))
+	for _, d := range s.pending {
+		stored = append(stored, d)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].DeliverAt.Before(stored[j].DeliverAt) })
+
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
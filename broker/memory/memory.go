@@ -0,0 +1,354 @@
+// Package memory provides an in-process implementation of broker.Broker
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+)
+
+// DefaultMaxDeliveries is how often a message is delivered before it is
+// handed to the ErrorHandler
+const DefaultMaxDeliveries = 3
+
+// ErrNotAcked is the event error of a message that a subscriber with
+// broker.DisableAutoAck handled without calling Event.Ack
+var ErrNotAcked = errors.New("memory: message was not acknowledged")
+
+type loggerKey struct{}
+type maxDeliveriesKey struct{}
+
+// Logger sets the logger used for handler errors when no
+// broker.ErrorHandler is configured, zap.L() is used by default
+func Logger(logger *zap.Logger) broker.Option {
+	return func(o *broker.Options) {
+		o.Context = context.WithValue(o.Context, loggerKey{}, logger)
+	}
+}
+
+// MaxDeliveries sets how often a message that fails or is not acknowledged
+// is delivered before it is handed to the ErrorHandler
+func MaxDeliveries(n int) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, maxDeliveriesKey{}, n)
+	}
+}
+
+type memoryBroker struct {
+	opts broker.Options
+	addr string
+	sync.RWMutex
+	connected   bool
+	subscribers map[string][]*memorySubscriber
+	delays      *scheduler
+	// cursors holds the round robin position of every queue group
+	cursors  map[string]int
+	cursorMu sync.Mutex
+}
+
+type memoryEvent struct {
+	opts    broker.Options
+	topic   string
+	message *broker.Message
+	err     error
+	sync.Mutex
+	acked bool
+}
+
+type memorySubscriber struct {
+	id      string
+	topic   string
+	handler broker.Handler
+	opts    broker.SubscribeOptions
+	broker  *memoryBroker
+}
+
+// NewBroker returns a broker that delivers messages inside the current process
+func NewBroker(opts ...broker.Option) broker.Broker {
+	return &memoryBroker{
+		opts:        broker.NewOptions(opts...),
+		subscribers: make(map[string][]*memorySubscriber),
+		cursors:     make(map[string]int),
+	}
+}
+
+func (m *memoryBroker) Options() broker.Options {
+	return m.opts
+}
+
+func (m *memoryBroker) Address() string {
+	return m.addr
+}
+
+func (m *memoryBroker) Connect() error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.connected {
+		return nil
+	}
+
+	m.addr = "memory"
+	if len(m.opts.Addrs) > 0 {
+		m.addr = m.opts.Addrs[0]
+	}
+
+	if m.delays == nil {
+		path, _ := m.opts.Context.Value(delayStoreKey{}).(string)
+		m.delays = newScheduler(path, m.publish)
+	}
+	m.connected = true
+
+	return m.delays.start()
+}
+
+func (m *memoryBroker) Disconnect() error {
+	m.Lock()
+	defer m.Unlock()
+
+	if !m.connected {
+		return nil
+	}
+
+	m.connected = false
+	m.subscribers = make(map[string][]*memorySubscriber)
+	m.delays.stop()
+	return nil
+}
+
+func (m *memoryBroker) Init(opts ...broker.Option) error {
+	for _, o := range opts {
+		o(&m.opts)
+	}
+	return nil
+}
+
+func (m *memoryBroker) Publish(topic string, msg *broker.Message, opts ...broker.PublishOption) error {
+	options := broker.NewPublishOptions(opts...)
+	if options.DeliverAt.After(time.Now()) {
+		m.RLock()
+		connected := m.connected
+		m.RUnlock()
+		if !connected {
+			return broker.ErrNotConnected
+		}
+		return m.delays.add(topic, msg, options.DeliverAt)
+	}
+
+	return m.publish(topic, msg)
+}
+
+// publish delivers the message to the current subscribers of the topic
+func (m *memoryBroker) publish(topic string, msg *broker.Message) error {
+	m.RLock()
+	if !m.connected {
+		m.RUnlock()
+		return broker.ErrNotConnected
+	}
+	receivers := m.receivers(topic)
+	m.RUnlock()
+
+	if len(receivers) == 0 {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for _, candidates := range receivers {
+		wg.Add(1)
+		go func(candidates []*memorySubscriber) {
+			defer wg.Done()
+			m.deliver(candidates, msg)
+		}(candidates)
+	}
+	wg.Wait()
+
+	// handler errors belong to the subscribers and are not returned to the publisher
+	return nil
+}
+
+func (m *memoryBroker) Subscribe(topic string, handler broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	m.RLock()
+	if !m.connected {
+		m.RUnlock()
+		return nil, broker.ErrNotConnected
+	}
+	m.RUnlock()
+
+	sub := &memorySubscriber{
+		id:      uuid.NewV4().String(),
+		topic:   topic,
+		handler: handler,
+		opts:    broker.NewSubscribeOptions(opts...),
+		broker:  m,
+	}
+
+	m.Lock()
+	m.subscribers[topic] = append(m.subscribers[topic], sub)
+	m.Unlock()
+
+	return sub, nil
+}
+
+// receivers returns one candidate list per delivery: every plain subscriber
+// of the topic gets its own list, and each queue group gets its members in
+// round robin order starting with the one that receives the message.
+// The caller must hold the read lock.
+func (m *memoryBroker) receivers(topic string) [][]*memorySubscriber {
+	subs := m.subscribers[topic]
+	result := make([][]*memorySubscriber, 0, len(subs))
+	groups := make(map[string][]*memorySubscriber)
+	var queues []string
+
+	for _, sub := range subs {
+		if len(sub.opts.Queue) == 0 {
+			result = append(result, []*memorySubscriber{sub})
+			continue
+		}
+		if _, ok := groups[sub.opts.Queue]; !ok {
+			queues = append(queues, sub.opts.Queue)
+		}
+		groups[sub.opts.Queue] = append(groups[sub.opts.Queue], sub)
+	}
+
+	m.cursorMu.Lock()
+	for _, queue := range queues {
+		members := groups[queue]
+		key := topic + "\x00" + queue
+		start := m.cursors[key] % len(members)
+		m.cursors[key] = start + 1
+
+		ordered := make([]*memorySubscriber, 0, len(members))
+		ordered = append(ordered, members[start:]...)
+		ordered = append(ordered, members[:start]...)
+		result = append(result, ordered)
+	}
+	m.cursorMu.Unlock()
+
+	return result
+}
+
+func (m *memoryBroker) unsubscribe(sub *memorySubscriber) {
+	m.Lock()
+	defer m.Unlock()
+
+	subs := m.subscribers[sub.topic]
+	for i, s := range subs {
+		if s.id == sub.id {
+			m.subscribers[sub.topic] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+
+	if len(m.subscribers[sub.topic]) == 0 {
+		delete(m.subscribers, sub.topic)
+	}
+}
+
+// deliver hands the message to the first candidate. A message whose handler
+// returns an error, or returns without Event.Ack when auto ack is disabled,
+// is delivered again, to the next member in a queue group, up to
+// MaxDeliveries times. Acknowledgements are only checked when the handler
+// returns, acking later from another goroutine has no effect.
+func (m *memoryBroker) deliver(candidates []*memorySubscriber, msg *broker.Message) {
+	var evt *memoryEvent
+	limit := maxDeliveries(candidates[0].opts)
+	for attempt := 0; attempt < limit; attempt++ {
+		if evt = candidates[attempt%len(candidates)].handle(msg); evt.err == nil {
+			return
+		}
+	}
+
+	if eh := evt.opts.ErrorHandler; eh != nil {
+		_ = eh(evt)
+		return
+	}
+	m.logger().Error("消息处理失败", zap.String("topic", evt.topic), zap.Error(evt.err))
+}
+
+func (m *memoryBroker) logger() *zap.Logger {
+	if logger, ok := m.opts.Context.Value(loggerKey{}).(*zap.Logger); ok && logger != nil {
+		return logger
+	}
+	return zap.L()
+}
+
+// handle runs the subscriber handler with a private copy of the message
+func (s *memorySubscriber) handle(msg *broker.Message) *memoryEvent {
+	evt := &memoryEvent{
+		opts:    s.broker.opts,
+		topic:   s.topic,
+		message: copyMessage(msg),
+	}
+
+	evt.err = s.handler(evt)
+	if evt.err == nil {
+		if s.opts.AutoAck {
+			_ = evt.Ack()
+		} else if !evt.isAcked() {
+			evt.err = ErrNotAcked
+		}
+	}
+
+	return evt
+}
+
+func maxDeliveries(o broker.SubscribeOptions) int {
+	if v, ok := o.Context.Value(maxDeliveriesKey{}).(int); ok && v > 0 {
+		return v
+	}
+	return DefaultMaxDeliveries
+}
+
+func (s *memorySubscriber) Options() broker.SubscribeOptions {
+	return s.opts
+}
+
+func (s *memorySubscriber) Topic() string {
+	return s.topic
+}
+
+func (s *memorySubscriber) Unsubscribe() error {
+	s.broker.unsubscribe(s)
+	return nil
+}
+
+func (e *memoryEvent) Topic() string {
+	return e.topic
+}
+
+func (e *memoryEvent) Message() *broker.Message {
+	return e.message
+}
+
+func (e *memoryEvent) Ack() error {
+	e.Lock()
+	e.acked = true
+	e.Unlock()
+	return nil
+}
+
+func (e *memoryEvent) isAcked() bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.acked
+}
+
+func (e *memoryEvent) Error() error {
+	return e.err
+}
+
+func copyMessage(msg *broker.Message) *broker.Message {
+	header := make(map[string]string, len(msg.Header))
+	for k, v := range msg.Header {
+		header[k] = v
+	}
+	body := make([]byte, len(msg.Body))
+	copy(body, msg.Body)
+	return &broker.Message{Header: header, Body: body}
+}
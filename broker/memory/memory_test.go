@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	t.Run("PublishBeforeConnect", func(t *testing.T) {
+		b := NewBroker()
+		err := b.Publish("test", &broker.Message{Body: []byte("hello")})
+		require.ErrorIs(t, err, broker.ErrNotConnected)
+	})
+
+	t.Run("FanOut", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var count int32
+		for i := 0; i < 5; i++ {
+			_, err := b.Subscribe("test", func(e broker.Event) error {
+				if string(e.Message().Body) == "hello" {
+					atomic.AddInt32(&count, 1)
+				}
+				return nil
+			})
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, b.Publish("test", &broker.Message{Body: []byte("hello")}))
+		require.Equal(t, int32(5), atomic.LoadInt32(&count))
+	})
+
+	t.Run("QueueGroup", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var group, plain int32
+		for i := 0; i < 3; i++ {
+			_, err := b.Subscribe("test", func(e broker.Event) error {
+				atomic.AddInt32(&group, 1)
+				return nil
+			}, broker.Queue("workers"))
+			require.NoError(t, err)
+		}
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&plain, 1)
+			return nil
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, b.Publish("test", &broker.Message{}))
+		}
+		require.Equal(t, int32(10), atomic.LoadInt32(&group))
+		require.Equal(t, int32(10), atomic.LoadInt32(&plain))
+	})
+
+	t.Run("QueueGroupRoundRobin", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		counts := make([]int32, 3)
+		for i := range counts {
+			i := i
+			_, err := b.Subscribe("test", func(e broker.Event) error {
+				atomic.AddInt32(&counts[i], 1)
+				return e.Ack()
+			}, broker.Queue("workers"), broker.DisableAutoAck())
+			require.NoError(t, err)
+		}
+
+		for i := 0; i < 9; i++ {
+			require.NoError(t, b.Publish("test", &broker.Message{}))
+		}
+		for i := range counts {
+			require.Equal(t, int32(3), atomic.LoadInt32(&counts[i]))
+		}
+	})
+
+	t.Run("QueueGroupRedeliversOnError", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var failed, handled int32
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&failed, 1)
+			return errors.New("unavailable")
+		}, broker.Queue("workers"))
+		require.NoError(t, err)
+		_, err = b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&handled, 1)
+			return nil
+		}, broker.Queue("workers"))
+		require.NoError(t, err)
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, b.Publish("test", &broker.Message{}))
+		}
+		require.Equal(t, int32(10), atomic.LoadInt32(&handled))
+		require.Equal(t, int32(5), atomic.LoadInt32(&failed))
+	})
+
+	t.Run("RedeliverUnacked", func(t *testing.T) {
+		var (
+			mu     sync.Mutex
+			failed []broker.Event
+		)
+		b := NewBroker(broker.ErrorHandler(func(e broker.Event) error {
+			mu.Lock()
+			failed = append(failed, e)
+			mu.Unlock()
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		// the first delivery returns without Ack and must be delivered again
+		var acked int32
+		_, err := b.Subscribe("acked", func(e broker.Event) error {
+			if atomic.AddInt32(&acked, 1) == 1 {
+				return nil
+			}
+			return e.Ack()
+		}, broker.DisableAutoAck())
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("acked", &broker.Message{}))
+		require.Equal(t, int32(2), atomic.LoadInt32(&acked))
+
+		var never int32
+		_, err = b.Subscribe("never", func(e broker.Event) error {
+			atomic.AddInt32(&never, 1)
+			return nil
+		}, broker.DisableAutoAck(), MaxDeliveries(2))
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("never", &broker.Message{}))
+		require.Equal(t, int32(2), atomic.LoadInt32(&never))
+		require.Len(t, failed, 1)
+		require.ErrorIs(t, failed[0].Error(), ErrNotAcked)
+	})
+
+	t.Run("HandlerErrorNotReturned", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			return errors.New("boom")
+		})
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("test", &broker.Message{}))
+	})
+
+	t.Run("ErrorHandler", func(t *testing.T) {
+		var (
+			mu     sync.Mutex
+			failed []broker.Event
+		)
+		errHandler := func(e broker.Event) error {
+			mu.Lock()
+			failed = append(failed, e)
+			mu.Unlock()
+			return nil
+		}
+		b := NewBroker(broker.ErrorHandler(errHandler))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		boom := errors.New("boom")
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			return boom
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("test", &broker.Message{}))
+		require.Len(t, failed, 1)
+		require.ErrorIs(t, failed[0].Error(), boom)
+		require.Equal(t, "test", failed[0].Topic())
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var count int32
+		sub, err := b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("test", &broker.Message{}))
+		require.NoError(t, sub.Unsubscribe())
+		require.NoError(t, b.Publish("test", &broker.Message{}))
+		require.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+
+	t.Run("DeliverAfter", func(t *testing.T) {
+		b := NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var count int32
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("test", &broker.Message{}, broker.DeliverAfter(50*time.Millisecond)))
+		require.Equal(t, int32(0), atomic.LoadInt32(&count))
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&count) == 1
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("DelayStoreSurvivesRestart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "delayed.json")
+
+		b := NewBroker(DelayStore(path))
+		require.NoError(t, b.Connect())
+		require.NoError(t, b.Publish("test", &broker.Message{Body: []byte("later")}, broker.DeliverAfter(100*time.Millisecond)))
+		require.NoError(t, b.Disconnect())
+
+		b = NewBroker(DelayStore(path))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		received := make(chan string, 1)
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			received <- string(e.Message().Body)
+			return nil
+		})
+		require.NoError(t, err)
+
+		select {
+		case body := <-received:
+			require.Equal(t, "later", body)
+		case <-time.After(2 * time.Second):
+			t.Fatal("delayed message was not delivered after restart")
+		}
+
+		// 投递后待发送消息从存储中移除
+		require.Eventually(t, func() bool {
+			data, err := ioutil.ReadFile(path)
+			return err == nil && string(data) == "[]"
+		}, time.Second, 5*time.Millisecond)
+	})
+}
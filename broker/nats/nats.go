@@ -0,0 +1,742 @@
+// Package nats implements broker.Broker over the NATS text protocol
+package nats
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+)
+
+const (
+	defaultAddr    = "127.0.0.1:4222"
+	headerVersion  = "NATS/1.0"
+	dialTimeout    = 5 * time.Second
+	writeTimeout   = 5 * time.Second
+	maxReconnect   = 2 * time.Second
+	pendingMsgSize = 256
+)
+
+var (
+	// ErrHeadersNotSupported is returned when a message with headers is
+	// published to a server that did not announce header support.
+	ErrHeadersNotSupported = errors.New("nats: server does not support headers")
+	// ErrInvalidSubject is returned when the topic can not be used as a NATS subject
+	ErrInvalidSubject = errors.New("nats: invalid subject")
+	// ErrInvalidHeader is returned for a header key containing ':' or a
+	// header key or value containing CR or LF
+	ErrInvalidHeader = errors.New("nats: invalid header")
+	// ErrSlowConsumer is reported to the ErrorHandler for a message dropped
+	// because the pending buffer of its subscriber was full
+	ErrSlowConsumer = errors.New("nats: slow consumer, message dropped")
+)
+
+type writeTimeoutKey struct{}
+
+// WriteTimeout sets how long a write waits for a stalled server, the
+// connection is dropped and reconnected when it expires. 5s by default.
+func WriteTimeout(d time.Duration) broker.Option {
+	return func(o *broker.Options) {
+		o.Context = context.WithValue(o.Context, writeTimeoutKey{}, d)
+	}
+}
+
+// serverInfo is the payload of the INFO message sent by the server
+type serverInfo struct {
+	ServerID     string `json:"server_id"`
+	Version      string `json:"version"`
+	Headers      bool   `json:"headers"`
+	MaxPayload   int64  `json:"max_payload"`
+	TLSRequired  bool   `json:"tls_required"`
+	AuthRequired bool   `json:"auth_required"`
+}
+
+// connectInfo is the payload of the CONNECT message sent by the client
+type connectInfo struct {
+	Verbose  bool   `json:"verbose"`
+	Pedantic bool   `json:"pedantic"`
+	TLS      bool   `json:"tls_required"`
+	Name     string `json:"name"`
+	Lang     string `json:"lang"`
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	Headers  bool   `json:"headers"`
+	User     string `json:"user,omitempty"`
+	Pass     string `json:"pass,omitempty"`
+}
+
+type natsBroker struct {
+	opts broker.Options
+	sync.RWMutex
+	addr      string
+	conn      net.Conn
+	info      serverInfo
+	connected bool
+	closing   bool
+	sid       int64
+	subs      map[int64]*natsSubscriber
+
+	wmu   sync.Mutex
+	wconn net.Conn
+	bw    *bufio.Writer
+}
+
+type natsSubscriber struct {
+	sid     int64
+	topic   string
+	opts    broker.SubscribeOptions
+	handler broker.Handler
+	broker  *natsBroker
+	msgs    chan *natsEvent
+	done    chan struct{}
+	once    sync.Once
+}
+
+type natsEvent struct {
+	topic   string
+	message *broker.Message
+	err     error
+}
+
+// NewBroker returns a broker speaking the NATS client protocol
+func NewBroker(opts ...broker.Option) broker.Broker {
+	return &natsBroker{
+		opts: broker.NewOptions(opts...),
+		subs: make(map[int64]*natsSubscriber),
+	}
+}
+
+func (n *natsBroker) Init(opts ...broker.Option) error {
+	for _, o := range opts {
+		o(&n.opts)
+	}
+	return nil
+}
+
+func (n *natsBroker) Options() broker.Options {
+	return n.opts
+}
+
+func (n *natsBroker) Address() string {
+	n.RLock()
+	defer n.RUnlock()
+	return n.addr
+}
+
+func (n *natsBroker) Connect() error {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.connected {
+		return nil
+	}
+
+	n.closing = false
+	return n.dial()
+}
+
+func (n *natsBroker) Disconnect() error {
+	n.Lock()
+	n.closing = true
+	if n.conn == nil {
+		n.Unlock()
+		return nil
+	}
+	connected := n.connected
+	n.connected = false
+	subs := n.subs
+	n.subs = make(map[int64]*natsSubscriber)
+	conn := n.conn
+	n.conn = nil
+	n.Unlock()
+
+	for _, sub := range subs {
+		sub.stop()
+	}
+
+	n.wmu.Lock()
+	if n.bw != nil {
+		_ = n.bw.Flush()
+	}
+	n.wmu.Unlock()
+
+	err := conn.Close()
+	if !connected {
+		// 连接已断开，正在重连
+		return nil
+	}
+	return err
+}
+
+func (n *natsBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	if !validSubject(topic) {
+		return ErrInvalidSubject
+	}
+
+	n.RLock()
+	connected, headers := n.connected, n.info.Headers
+	n.RUnlock()
+
+	if !connected {
+		return broker.ErrNotConnected
+	}
+
+	if len(m.Header) == 0 {
+		return n.write(fmt.Sprintf("PUB %s %d\r\n", topic, len(m.Body)), m.Body, []byte("\r\n"))
+	}
+
+	if !headers {
+		return ErrHeadersNotSupported
+	}
+
+	hdr, err := encodeHeader(m.Header)
+	if err != nil {
+		return err
+	}
+	return n.write(fmt.Sprintf("HPUB %s %d %d\r\n", topic, len(hdr), len(hdr)+len(m.Body)), hdr, m.Body, []byte("\r\n"))
+}
+
+func (n *natsBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	if !validSubject(topic) {
+		return nil, ErrInvalidSubject
+	}
+
+	n.Lock()
+	if !n.connected {
+		n.Unlock()
+		return nil, broker.ErrNotConnected
+	}
+	n.sid++
+	sub := &natsSubscriber{
+		sid:     n.sid,
+		topic:   topic,
+		opts:    broker.NewSubscribeOptions(opts...),
+		handler: h,
+		broker:  n,
+		msgs:    make(chan *natsEvent, pendingMsgSize),
+		done:    make(chan struct{}),
+	}
+	n.subs[sub.sid] = sub
+	n.Unlock()
+
+	go sub.run()
+
+	if err := n.write(sub.subCommand()); err != nil {
+		n.Lock()
+		delete(n.subs, sub.sid)
+		n.Unlock()
+		sub.stop()
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// dial connects to the first reachable server and performs the handshake.
+// The caller must hold the lock.
+func (n *natsBroker) dial() error {
+	conn, br, info, addr, err := n.connectAny()
+	if err != nil {
+		return err
+	}
+	n.attach(conn, br, info, addr)
+	return nil
+}
+
+// connectAny performs the handshake with the first reachable server, it
+// does not touch the broker state and is called without the lock.
+func (n *natsBroker) connectAny() (net.Conn, *bufio.Reader, serverInfo, string, error) {
+	addrs := n.opts.Addrs
+	if len(addrs) == 0 {
+		addrs = []string{defaultAddr}
+	}
+
+	var lastErr error
+	for _, addr := range addrs {
+		conn, br, info, err := n.handshake(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return conn, br, info, addr, nil
+	}
+
+	return nil, nil, serverInfo{}, "", lastErr
+}
+
+// attach makes the connection the current one and starts reading from it.
+// The caller must hold the lock.
+func (n *natsBroker) attach(conn net.Conn, br *bufio.Reader, info serverInfo, addr string) {
+	n.wmu.Lock()
+	n.conn = conn
+	n.wconn = conn
+	n.bw = bufio.NewWriter(conn)
+	n.wmu.Unlock()
+
+	n.addr = addr
+	n.info = info
+	n.connected = true
+
+	go n.readLoop(conn, br)
+}
+
+// handshake dials the server, reads its INFO, upgrades to TLS when
+// required and sends CONNECT followed by a PING round trip.
+func (n *natsBroker) handshake(addr string) (net.Conn, *bufio.Reader, serverInfo, error) {
+	var info serverInfo
+
+	host, secure, user, pass, err := parseAddr(addr)
+	if err != nil {
+		return nil, nil, info, err
+	}
+	secure = secure || n.opts.Secure
+
+	conn, err := net.DialTimeout("tcp", host, dialTimeout)
+	if err != nil {
+		return nil, nil, info, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(dialTimeout))
+
+	br := bufio.NewReader(conn)
+	line, err := readLine(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, info, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, info, fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "INFO ")), &info); err != nil {
+		conn.Close()
+		return nil, nil, info, err
+	}
+
+	if secure || info.TLSRequired {
+		cfg := n.opts.TLSConfig
+		if cfg == nil {
+			cfg = &tls.Config{}
+		} else {
+			cfg = cfg.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(host)
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, nil, info, err
+		}
+		conn = tlsConn
+		br = bufio.NewReader(conn)
+		secure = true
+	}
+
+	connect, err := json.Marshal(connectInfo{
+		TLS:      secure,
+		Name:     "go-titan",
+		Lang:     "go",
+		Version:  "1.0.0",
+		Protocol: 1,
+		Headers:  true,
+		User:     user,
+		Pass:     pass,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, nil, info, err
+	}
+
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return nil, nil, info, err
+	}
+
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			conn.Close()
+			return nil, nil, info, err
+		}
+		switch {
+		case line == "PONG":
+			_ = conn.SetDeadline(time.Time{})
+			return conn, br, info, nil
+		case strings.HasPrefix(line, "-ERR"):
+			conn.Close()
+			return nil, nil, info, protocolError(line)
+		}
+	}
+}
+
+// readLoop processes the protocol messages sent by the server
+func (n *natsBroker) readLoop(conn net.Conn, br *bufio.Reader) {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			n.disconnected(conn)
+			return
+		}
+
+		op := line
+		args := ""
+		if i := strings.IndexByte(line, ' '); i > 0 {
+			op, args = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(op) {
+		case "MSG":
+			err = n.processMsg(br, args, false)
+		case "HMSG":
+			err = n.processMsg(br, args, true)
+		case "PING":
+			err = n.write("PONG\r\n")
+		case "INFO":
+			var info serverInfo
+			if json.Unmarshal([]byte(args), &info) == nil {
+				n.Lock()
+				n.info = info
+				n.Unlock()
+			}
+		}
+
+		if err != nil {
+			n.disconnected(conn)
+			return
+		}
+	}
+}
+
+// processMsg reads the payload of a MSG or HMSG and hands it to the subscriber
+func (n *natsBroker) processMsg(br *bufio.Reader, args string, withHeader bool) error {
+	fields := strings.Fields(args)
+
+	var (
+		hdrLen, total int
+		err           error
+	)
+
+	// MSG <subject> <sid> [reply-to] <#bytes>
+	// HMSG <subject> <sid> [reply-to] <#header bytes> <#total bytes>
+	switch {
+	case !withHeader && (len(fields) == 3 || len(fields) == 4):
+		total, err = strconv.Atoi(fields[len(fields)-1])
+	case withHeader && (len(fields) == 4 || len(fields) == 5):
+		if hdrLen, err = strconv.Atoi(fields[len(fields)-2]); err == nil {
+			total, err = strconv.Atoi(fields[len(fields)-1])
+		}
+	default:
+		return fmt.Errorf("nats: malformed message %q", args)
+	}
+	if err != nil {
+		return err
+	}
+
+	payload := make([]byte, total+2)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return err
+	}
+	payload = payload[:total]
+
+	msg := &broker.Message{Header: make(map[string]string)}
+	if withHeader {
+		if hdrLen > total {
+			return fmt.Errorf("nats: malformed message %q", args)
+		}
+		msg.Header, err = decodeHeader(payload[:hdrLen])
+		if err != nil {
+			return err
+		}
+		payload = payload[hdrLen:]
+	}
+	msg.Body = payload
+
+	sid, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	n.RLock()
+	sub, ok := n.subs[sid]
+	n.RUnlock()
+	if ok {
+		sub.push(&natsEvent{topic: fields[0], message: msg})
+	}
+	return nil
+}
+
+// disconnected reconnects after the connection has been lost. The lock is
+// only held to check and swap the connection, so Publish and Subscribe fail
+// fast with broker.ErrNotConnected during the outage instead of blocking.
+func (n *natsBroker) disconnected(conn net.Conn) {
+	conn.Close()
+
+	n.Lock()
+	if n.closing || n.conn != conn {
+		n.Unlock()
+		return
+	}
+	n.connected = false
+	n.Unlock()
+
+	backoff := 100 * time.Millisecond
+	for {
+		next, br, info, addr, err := n.connectAny()
+
+		n.Lock()
+		// 重连期间已断开或重新连接
+		if n.closing || n.conn != conn {
+			n.Unlock()
+			if err == nil {
+				next.Close()
+			}
+			return
+		}
+		if err == nil {
+			n.attach(next, br, info, addr)
+
+			// 恢复断线前的全部订阅
+			cmds := make([]string, 0, len(n.subs))
+			for _, sub := range n.subs {
+				cmds = append(cmds, sub.subCommand())
+			}
+			n.Unlock()
+
+			for _, cmd := range cmds {
+				_ = n.write(cmd)
+			}
+			return
+		}
+		n.Unlock()
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxReconnect {
+			backoff = maxReconnect
+		}
+	}
+}
+
+// write sends a protocol frame composed of a control line and optional payloads
+func (n *natsBroker) write(line string, payloads ...[]byte) error {
+	n.wmu.Lock()
+	defer n.wmu.Unlock()
+
+	if n.bw == nil {
+		return broker.ErrNotConnected
+	}
+
+	_ = n.wconn.SetWriteDeadline(time.Now().Add(n.writeTimeout()))
+	err := n.flush(line, payloads)
+	if err != nil {
+		// 写入失败后缓冲区不可再用，关闭连接由读取循环重连
+		n.wconn.Close()
+	}
+	return err
+}
+
+func (n *natsBroker) flush(line string, payloads [][]byte) error {
+	if _, err := n.bw.WriteString(line); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		if _, err := n.bw.Write(p); err != nil {
+			return err
+		}
+	}
+	return n.bw.Flush()
+}
+
+func (n *natsBroker) writeTimeout() time.Duration {
+	if d, ok := n.opts.Context.Value(writeTimeoutKey{}).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return writeTimeout
+}
+
+func (n *natsBroker) unsubscribe(sub *natsSubscriber) error {
+	n.Lock()
+	_, ok := n.subs[sub.sid]
+	delete(n.subs, sub.sid)
+	connected := n.connected
+	n.Unlock()
+
+	sub.stop()
+
+	if !ok || !connected {
+		return nil
+	}
+	return n.write(fmt.Sprintf("UNSUB %d\r\n", sub.sid))
+}
+
+func (s *natsSubscriber) subCommand() string {
+	if len(s.opts.Queue) > 0 {
+		return fmt.Sprintf("SUB %s %s %d\r\n", s.topic, s.opts.Queue, s.sid)
+	}
+	return fmt.Sprintf("SUB %s %d\r\n", s.topic, s.sid)
+}
+
+// push queues a message for the subscriber without blocking the read loop.
+// When the pending buffer is full the message is dropped and reported to
+// the ErrorHandler with ErrSlowConsumer, so that a slow handler does not
+// stall the other subscriptions and the PING/PONG exchange.
+func (s *natsSubscriber) push(evt *natsEvent) {
+	select {
+	case s.msgs <- evt:
+	case <-s.done:
+	default:
+		evt.err = ErrSlowConsumer
+		if eh := s.broker.opts.ErrorHandler; eh != nil {
+			_ = eh(evt)
+		}
+	}
+}
+
+func (s *natsSubscriber) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case evt := <-s.msgs:
+			evt.err = s.handler(evt)
+			if evt.err != nil {
+				if eh := s.broker.opts.ErrorHandler; eh != nil {
+					_ = eh(evt)
+				}
+			}
+		}
+	}
+}
+
+func (s *natsSubscriber) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *natsSubscriber) Options() broker.SubscribeOptions {
+	return s.opts
+}
+
+func (s *natsSubscriber) Topic() string {
+	return s.topic
+}
+
+func (s *natsSubscriber) Unsubscribe() error {
+	return s.broker.unsubscribe(s)
+}
+
+func (e *natsEvent) Topic() string {
+	return e.topic
+}
+
+func (e *natsEvent) Message() *broker.Message {
+	return e.message
+}
+
+// Ack is a no-op, core NATS has no acknowledgement
+func (e *natsEvent) Ack() error {
+	return nil
+}
+
+func (e *natsEvent) Error() error {
+	return e.err
+}
+
+// parseAddr accepts host:port as well as nats://, tls:// URLs with credentials
+func parseAddr(addr string) (host string, secure bool, user, pass string, err error) {
+	if !strings.Contains(addr, "://") {
+		return addr, false, "", "", nil
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", false, "", "", err
+	}
+
+	host = u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	return host, u.Scheme == "tls", user, pass, nil
+}
+
+// encodeHeader rejects keys and values that would end a header line early
+func encodeHeader(header map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(header))
+	for k, v := range header {
+		if k == "" || strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(headerVersion)
+	sb.WriteString("\r\n")
+	for _, k := range keys {
+		sb.WriteString(k)
+		sb.WriteString(": ")
+		sb.WriteString(header[k])
+		sb.WriteString("\r\n")
+	}
+	sb.WriteString("\r\n")
+	return []byte(sb.String()), nil
+}
+
+func decodeHeader(data []byte) (map[string]string, error) {
+	header := make(map[string]string)
+	tp := textproto.NewReader(bufio.NewReader(strings.NewReader(string(data))))
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, headerVersion) {
+		return nil, fmt.Errorf("nats: malformed header %q", line)
+	}
+
+	for {
+		line, err := tp.ReadLine()
+		if err != nil || line == "" {
+			break
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		header[line[:i]] = strings.TrimSpace(line[i+1:])
+	}
+	return header, nil
+}
+
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func validSubject(subject string) bool {
+	return subject != "" && !strings.ContainsAny(subject, " \t\r\n")
+}
+
+func protocolError(line string) error {
+	return errors.New("nats: " + strings.Trim(strings.TrimSpace(strings.TrimPrefix(line, "-ERR")), "'"))
+}
@@ -0,0 +1,488 @@
+package nats
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer is a minimal NATS compatible server supporting the subset of
+// the protocol used by the broker: PING/PONG, SUB/UNSUB, PUB/HPUB and queue groups.
+type testServer struct {
+	ln    net.Listener
+	mu    sync.Mutex
+	conns map[*testConn]struct{}
+	subs  []*testSub
+	next  int
+	tls   *tls.Config
+	// stall stops the server from reading after the first PONG until it is closed
+	stall chan struct{}
+}
+
+type testConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+type testSub struct {
+	conn    *testConn
+	subject string
+	queue   string
+	sid     string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTLSTestServer(t, nil)
+}
+
+// newTLSTestServer starts a server that requires TLS when cfg is not nil
+func newTLSTestServer(t *testing.T, cfg *tls.Config) *testServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &testServer{ln: ln, conns: make(map[*testConn]struct{}), tls: cfg}
+	go s.serve()
+	t.Cleanup(func() { s.close() })
+	return s
+}
+
+func (s *testServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *testServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := &testConn{Conn: c}
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// dropClients closes every client connection while keeping the listener open
+func (s *testServer) dropClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *testServer) close() {
+	s.ln.Close()
+	s.dropClients()
+}
+
+func (s *testServer) handle(conn *testConn) {
+	defer func() {
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		subs := s.subs[:0]
+		for _, sub := range s.subs {
+			if sub.conn != conn {
+				subs = append(subs, sub)
+			}
+		}
+		s.subs = subs
+		s.mu.Unlock()
+	}()
+
+	if s.tls == nil {
+		conn.send(`INFO {"server_id":"test","version":"2.9.0","headers":true,"max_payload":1048576}` + "\r\n")
+	} else {
+		conn.send(`INFO {"server_id":"test","version":"2.9.0","headers":true,"max_payload":1048576,"tls_required":true}` + "\r\n")
+		tlsConn := tls.Server(conn.Conn, s.tls)
+		if err := tlsConn.Handshake(); err != nil {
+			return
+		}
+		s.mu.Lock()
+		conn.Conn = tlsConn
+		s.mu.Unlock()
+	}
+
+	br := bufio.NewReader(conn)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "PING":
+			conn.send("PONG\r\n")
+			if s.stall != nil {
+				<-s.stall
+				return
+			}
+		case "SUB":
+			sub := &testSub{conn: conn, subject: fields[1], sid: fields[len(fields)-1]}
+			if len(fields) == 4 {
+				sub.queue = fields[2]
+			}
+			s.mu.Lock()
+			s.subs = append(s.subs, sub)
+			s.mu.Unlock()
+		case "UNSUB":
+			s.mu.Lock()
+			subs := s.subs[:0]
+			for _, sub := range s.subs {
+				if sub.conn != conn || sub.sid != fields[1] {
+					subs = append(subs, sub)
+				}
+			}
+			s.subs = subs
+			s.mu.Unlock()
+		case "PUB", "HPUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(br, payload); err != nil {
+				return
+			}
+			hdr := ""
+			if fields[0] == "HPUB" {
+				hdr = fields[len(fields)-2]
+			}
+			s.route(fields[1], hdr, payload[:size])
+		}
+	}
+}
+
+func (s *testServer) route(subject, hdr string, payload []byte) {
+	s.mu.Lock()
+	targets := make([]*testSub, 0)
+	groups := make(map[string][]*testSub)
+	for _, sub := range s.subs {
+		if sub.subject != subject {
+			continue
+		}
+		if sub.queue == "" {
+			targets = append(targets, sub)
+		} else {
+			groups[sub.queue] = append(groups[sub.queue], sub)
+		}
+	}
+	for _, members := range groups {
+		s.next++
+		targets = append(targets, members[s.next%len(members)])
+	}
+	s.mu.Unlock()
+
+	for _, sub := range targets {
+		if hdr == "" {
+			sub.conn.send(fmt.Sprintf("MSG %s %s %d\r\n%s\r\n", subject, sub.sid, len(payload), payload))
+		} else {
+			sub.conn.send(fmt.Sprintf("HMSG %s %s %s %d\r\n%s\r\n", subject, sub.sid, hdr, len(payload), payload))
+		}
+	}
+}
+
+func (c *testConn) send(data string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, _ = c.Write([]byte(data))
+}
+
+// selfSignedCert returns a server certificate for 127.0.0.1 and a pool trusting it
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "nats-test"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestNatsBroker(t *testing.T) {
+	t.Run("ConnectFailed", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		b := NewBroker(broker.Addrs(addr))
+		require.Error(t, b.Connect())
+		require.ErrorIs(t, b.Publish("test", &broker.Message{}), broker.ErrNotConnected)
+	})
+
+	t.Run("PublishSubscribe", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs("nats://" + srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		require.Equal(t, "nats://"+srv.addr(), b.Address())
+
+		received := make(chan broker.Event, 1)
+		_, err := b.Subscribe("orders.created", func(e broker.Event) error {
+			received <- e
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = b.Publish("orders.created", &broker.Message{
+			Header: map[string]string{"Content-Type": "application/json", "X-Id": "42"},
+			Body:   []byte(`{"id":42}`),
+		})
+		require.NoError(t, err)
+
+		select {
+		case e := <-received:
+			msg := e.Message()
+			require.Equal(t, "orders.created", e.Topic())
+			require.Equal(t, `{"id":42}`, string(msg.Body))
+			require.Equal(t, "application/json", msg.Header["Content-Type"])
+			require.Equal(t, "42", msg.Header["X-Id"])
+		case <-time.After(2 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+	})
+
+	t.Run("QueueGroup", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var count int32
+		done := make(chan struct{}, 10)
+		for i := 0; i < 3; i++ {
+			_, err := b.Subscribe("jobs", func(e broker.Event) error {
+				atomic.AddInt32(&count, 1)
+				done <- struct{}{}
+				return nil
+			}, broker.Queue("workers"))
+			require.NoError(t, err)
+		}
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, b.Publish("jobs", &broker.Message{Body: []byte("job")}))
+		}
+		for i := 0; i < 10; i++ {
+			select {
+			case <-done:
+			case <-time.After(2 * time.Second):
+				t.Fatal("message was not delivered")
+			}
+		}
+		<-time.After(50 * time.Millisecond)
+		require.Equal(t, int32(10), atomic.LoadInt32(&count))
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var count int32
+		sub, err := b.Subscribe("test", func(e broker.Event) error {
+			atomic.AddInt32(&count, 1)
+			return nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, sub.Unsubscribe())
+		require.NoError(t, b.Publish("test", &broker.Message{}))
+
+		<-time.After(50 * time.Millisecond)
+		require.Equal(t, int32(0), atomic.LoadInt32(&count))
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		received := make(chan struct{}, 1)
+		_, err := b.Subscribe("test", func(e broker.Event) error {
+			select {
+			case received <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		require.NoError(t, err)
+
+		srv.dropClients()
+
+		require.Eventually(t, func() bool {
+			_ = b.Publish("test", &broker.Message{})
+			select {
+			case <-received:
+				return true
+			case <-time.After(20 * time.Millisecond):
+				return false
+			}
+		}, 3*time.Second, 50*time.Millisecond)
+	})
+
+	t.Run("Secure", func(t *testing.T) {
+		cert, pool := selfSignedCert(t)
+		srv := newTLSTestServer(t, &tls.Config{Certificates: []tls.Certificate{cert}})
+
+		b := NewBroker(broker.Addrs(srv.addr()), broker.Secure(true), broker.TLSConfig(&tls.Config{RootCAs: pool}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		received := make(chan broker.Event, 1)
+		_, err := b.Subscribe("secure", func(e broker.Event) error {
+			received <- e
+			return nil
+		})
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("secure", &broker.Message{Body: []byte("secret")}))
+
+		select {
+		case e := <-received:
+			require.Equal(t, "secret", string(e.Message().Body))
+		case <-time.After(2 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+
+		// 不受信任的证书无法建立连接
+		untrusted := NewBroker(broker.Addrs(srv.addr()), broker.Secure(true))
+		require.Error(t, untrusted.Connect())
+	})
+
+	t.Run("SlowConsumer", func(t *testing.T) {
+		dropped := make(chan broker.Event, pendingMsgSize)
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()), broker.ErrorHandler(func(e broker.Event) error {
+			dropped <- e
+			return nil
+		}))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		release := make(chan struct{})
+		defer close(release)
+		_, err := b.Subscribe("slow", func(e broker.Event) error {
+			<-release
+			return nil
+		})
+		require.NoError(t, err)
+
+		fast := make(chan struct{}, 1)
+		_, err = b.Subscribe("fast", func(e broker.Event) error {
+			fast <- struct{}{}
+			return nil
+		})
+		require.NoError(t, err)
+
+		for i := 0; i < pendingMsgSize+2; i++ {
+			require.NoError(t, b.Publish("slow", &broker.Message{}))
+		}
+		select {
+		case e := <-dropped:
+			require.ErrorIs(t, e.Error(), ErrSlowConsumer)
+		case <-time.After(2 * time.Second):
+			t.Fatal("slow consumer was not reported")
+		}
+
+		// 其他订阅不受影响
+		require.NoError(t, b.Publish("fast", &broker.Message{}))
+		select {
+		case <-fast:
+		case <-time.After(2 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+	})
+
+	t.Run("PublishDuringOutage", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		srv.close()
+		require.Eventually(t, func() bool {
+			return b.Publish("test", &broker.Message{}) == broker.ErrNotConnected
+		}, time.Second, 10*time.Millisecond)
+
+		// 重连过程中不持有锁，发布立即返回
+		start := time.Now()
+		require.ErrorIs(t, b.Publish("test", &broker.Message{}), broker.ErrNotConnected)
+		require.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+		require.NoError(t, b.Disconnect())
+	})
+
+	t.Run("InvalidHeader", func(t *testing.T) {
+		srv := newTestServer(t)
+		b := NewBroker(broker.Addrs(srv.addr()))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		for _, header := range []map[string]string{
+			{"X-Trace": "1\r\nX-Admin: true"},
+			{"X-Trace": "1\n"},
+			{"X-Bad:Key": "1"},
+			{"X-Bad\r\nKey": "1"},
+		} {
+			err := b.Publish("test", &broker.Message{Header: header})
+			require.ErrorIs(t, err, ErrInvalidHeader)
+		}
+		require.NoError(t, b.Publish("test", &broker.Message{Header: map[string]string{"X-Trace": "1"}}))
+	})
+
+	t.Run("StalledServer", func(t *testing.T) {
+		srv := newTestServer(t)
+		srv.stall = make(chan struct{})
+		defer close(srv.stall)
+
+		b := NewBroker(broker.Addrs(srv.addr()), WriteTimeout(100*time.Millisecond))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		// the body exceeds the socket buffers, so the write blocks until the deadline
+		start := time.Now()
+		err := b.Publish("test", &broker.Message{Body: make([]byte, 64<<20)})
+		require.Error(t, err)
+		require.Less(t, int64(time.Since(start)), int64(2*time.Second))
+	})
+
+	t.Run("InvalidSubject", func(t *testing.T) {
+		b := NewBroker()
+		require.ErrorIs(t, b.Publish("bad subject", &broker.Message{}), ErrInvalidSubject)
+	})
+}
@@ -2,20 +2,23 @@ package broker
 
 import (
 	"context"
+	"crypto/tls"
+	"time"
 
+	"github.com/dotnetage/go-titan/codec"
 	"github.com/dotnetage/go-titan/registry"
 )
 
 type Options struct {
 	Addrs  []string
 	Secure bool
-	// Codec  codec.Marshaler
+	Codec  codec.Marshaler
 
 	// Handler executed when error happens in broker mesage
 	// processing
 	ErrorHandler Handler
 
-	// TLSConfig *tls.Config
+	TLSConfig *tls.Config
 	// Registry used for clustering
 	Registry registry.Registry
 
@@ -25,6 +28,10 @@ type Options struct {
 }
 
 type PublishOptions struct {
+	// DeliverAt holds the message back until the given time,
+	// the zero value delivers it immediately
+	DeliverAt time.Time
+
 	// Other options for implementations of the interface
 	// can be stored in a context
 	Context context.Context
@@ -55,11 +62,51 @@ func PublishContext(ctx context.Context) PublishOption {
 	}
 }
 
+// DeliverAt hands the message to subscribers at the given time
+func DeliverAt(t time.Time) PublishOption {
+	return func(o *PublishOptions) {
+		o.DeliverAt = t
+	}
+}
+
+// DeliverAfter hands the message to subscribers once d has elapsed
+func DeliverAfter(d time.Duration) PublishOption {
+	return DeliverAt(time.Now().Add(d))
+}
+
 type SubscribeOption func(*SubscribeOptions)
 
+// NewOptions returns broker options with the defaults applied
+func NewOptions(opts ...Option) Options {
+	opt := Options{
+		Codec:   codec.JSON{},
+		Context: context.Background(),
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
+// NewPublishOptions returns publish options with the defaults applied
+func NewPublishOptions(opts ...PublishOption) PublishOptions {
+	opt := PublishOptions{
+		Context: context.Background(),
+	}
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	return opt
+}
+
 func NewSubscribeOptions(opts ...SubscribeOption) SubscribeOptions {
 	opt := SubscribeOptions{
 		AutoAck: true,
+		Context: context.Background(),
 	}
 
 	for _, o := range opts {
@@ -78,11 +125,11 @@ func Addrs(addrs ...string) Option {
 
 // Codec sets the codec used for encoding/decoding used where
 // a broker does not support headers
-// func Codec(c codec.Marshaler) Option {
-// 	return func(o *Options) {
-// 		o.Codec = c
-// 	}
-// }
+func Codec(c codec.Marshaler) Option {
+	return func(o *Options) {
+		o.Codec = c
+	}
+}
 
 // DisableAutoAck will disable auto acking of messages
 // after they have been handled.
@@ -120,12 +167,12 @@ func Secure(b bool) Option {
 	}
 }
 
-// Specify TLS Config
-// func TLSConfig(t *tls.Config) Option {
-// 	return func(o *Options) {
-// 		o.TLSConfig = t
-// 	}
-// }
+// TLSConfig specifies the TLS config used when Secure is enabled
+func TLSConfig(t *tls.Config) Option {
+	return func(o *Options) {
+		o.TLSConfig = t
+	}
+}
 
 // SubscribeContext set context
 func SubscribeContext(ctx context.Context) SubscribeOption {
@@ -0,0 +1,100 @@
+package outbox
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type Option func(*Options)
+
+// Options 发件箱中继的运行选项
+type Options struct {
+	Interval  time.Duration // 轮询待发送消息的间隔
+	BatchSize int           // 每次读取的最大消息数
+	// ClaimTimeout 中继认领消息后的有效期，超时仍未发送的消息可被其他中继重新认领
+	ClaimTimeout time.Duration
+	// Table 发件箱的数据表名，默认为 DefaultTable
+	Table string
+	// MaxAttempts 消息的最大发送次数，达到后转入 DeadLetter 并不再发送
+	MaxAttempts int
+	// DeadLetter 多次发送失败的消息转入的主题，为空时只标记为失败
+	DeadLetter string
+	Logger     *zap.Logger
+}
+
+func newOptions(opts ...Option) *Options {
+	options := &Options{
+		Interval:     time.Second,
+		BatchSize:    100,
+		ClaimTimeout: time.Minute,
+		Table:        DefaultTable,
+		MaxAttempts:  10,
+		Logger:       zap.NewNop(),
+	}
+
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// Interval 设置轮询待发送消息的间隔
+func Interval(d time.Duration) Option {
+	return func(o *Options) {
+		if d > 0 {
+			o.Interval = d
+		}
+	}
+}
+
+// BatchSize 设置每次发送的最大消息数
+func BatchSize(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.BatchSize = n
+		}
+	}
+}
+
+// ClaimTimeout 设置中继认领消息后的有效期，应大于发送一批消息所需的时间
+func ClaimTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		if d > 0 {
+			o.ClaimTimeout = d
+		}
+	}
+}
+
+// Table 设置发件箱的数据表名，同一进程中的多个发件箱可使用不同的数据表。
+// 写入消息时须使用该发件箱的 Enqueue 方法
+func Table(name string) Option {
+	return func(o *Options) {
+		if name != "" {
+			o.Table = name
+		}
+	}
+}
+
+// MaxAttempts 设置消息的最大发送次数，达到后不再阻塞后续消息
+func MaxAttempts(n int) Option {
+	return func(o *Options) {
+		if n > 0 {
+			o.MaxAttempts = n
+		}
+	}
+}
+
+// DeadLetter 设置多次发送失败的消息转入的主题
+func DeadLetter(topic string) Option {
+	return func(o *Options) {
+		o.DeadLetter = topic
+	}
+}
+
+func Logger(logger *zap.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
@@ -0,0 +1,293 @@
+// Package outbox implements the transactional outbox pattern: messages are
+// stored in the same database transaction as the business data and a relay
+// publishes them to a broker.Broker afterwards, with at-least-once delivery.
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/repository"
+
+	uuid "github.com/satori/go.uuid"
+	"go.uber.org/zap"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+const (
+	// HeaderMessageID carries the outbox row id so that consumers can drop duplicates
+	HeaderMessageID = "X-Outbox-Id"
+	// HeaderOriginalTopic holds the topic of a message moved to the dead-letter topic
+	HeaderOriginalTopic = "X-Original-Topic"
+	// HeaderLastError holds the last publish error of a dead-lettered message
+	HeaderLastError = "X-Outbox-Error"
+
+	// DefaultTable 发件箱默认的数据表名
+	DefaultTable = "outbox_messages"
+)
+
+// errNotGorm 仓库不是基于 gorm 实现时返回
+var errNotGorm = errors.New("发件箱需要基于gorm的仓库")
+
+// Message 发件箱中等待发送的消息
+type Message struct {
+	ID     uint64 `gorm:"primaryKey;autoIncrement"`
+	Topic  string `gorm:"type:varchar(255)"`
+	Header datatypes.JSON
+	Body   []byte
+	Sent   bool `gorm:"index"`
+	// Failed 发送次数达到 MaxAttempts 后放弃发送的消息，保留在数据表中以便排查
+	Failed    bool `gorm:"index"`
+	Attempts  int
+	LastError string `gorm:"type:varchar(1024)"`
+	// ClaimedBy 认领该消息的中继，ClaimedUntil 之前其他中继不会发送该消息
+	ClaimedBy    string `gorm:"type:varchar(64);index"`
+	ClaimedUntil *time.Time
+	CreatedAt    time.Time
+	SentAt       *time.Time
+}
+
+// TableName 发件箱默认的数据表名，使用 Table 选项的发件箱不受影响
+func (Message) TableName() string {
+	return DefaultTable
+}
+
+// Outbox 发件箱，负责将已提交的消息转发至消息代理
+type Outbox struct {
+	id      string
+	repos   repository.Repository
+	broker  broker.Broker
+	options *Options
+	logger  *zap.Logger
+
+	mu   sync.Mutex
+	exit chan struct{}
+	done chan struct{}
+}
+
+// New 创建发件箱，repos 用于读写发件箱数据表，b 为消息发送的目标
+func New(repos repository.Repository, b broker.Broker, opts ...Option) *Outbox {
+	options := newOptions(opts...)
+	return &Outbox{
+		id:      uuid.NewV4().String(),
+		repos:   repos,
+		broker:  b,
+		options: options,
+		logger:  options.Logger,
+	}
+}
+
+// Setup 初始化发件箱数据表
+func (o *Outbox) Setup() error {
+	db, err := table(o.repos, o.options.Table)
+	if err != nil {
+		return err
+	}
+	return db.AutoMigrate(&Message{})
+}
+
+// Enqueue 将消息写入默认数据表的发件箱，tx 应为当前事务内的仓库(见 Repository.Transaction)，
+// 消息只会在事务提交后才被中继发送
+func Enqueue(tx repository.Repository, topic string, m *broker.Message) error {
+	return enqueue(tx, DefaultTable, topic, m)
+}
+
+// Enqueue 将消息写入本发件箱的数据表，用法与包函数 Enqueue 相同
+func (o *Outbox) Enqueue(tx repository.Repository, topic string, m *broker.Message) error {
+	return enqueue(tx, o.options.Table, topic, m)
+}
+
+func enqueue(tx repository.Repository, name, topic string, m *broker.Message) error {
+	header, err := json.Marshal(m.Header)
+	if err != nil {
+		return err
+	}
+	db, err := table(tx, name)
+	if err != nil {
+		return err
+	}
+	return db.Create(&Message{
+		Topic:  topic,
+		Header: datatypes.JSON(header),
+		Body:   m.Body,
+	}).Error
+}
+
+// table 返回操作指定数据表的 gorm 会话
+func table(repos repository.Repository, name string) (*gorm.DB, error) {
+	db, ok := repos.DB().(*gorm.DB)
+	if !ok {
+		return nil, errNotGorm
+	}
+	return db.Table(name), nil
+}
+
+// Start 起动后台中继，按 Interval 轮询并发送待发送的消息
+func (o *Outbox) Start() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.exit != nil {
+		return
+	}
+	o.exit = make(chan struct{})
+	o.done = make(chan struct{})
+
+	go o.relay(o.exit, o.done)
+}
+
+// Stop 停止后台中继并等待正在进行的发送结束
+func (o *Outbox) Stop() {
+	o.mu.Lock()
+	exit, done := o.exit, o.done
+	o.exit, o.done = nil, nil
+	o.mu.Unlock()
+
+	if exit == nil {
+		return
+	}
+	close(exit)
+	<-done
+}
+
+func (o *Outbox) relay(exit, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(o.options.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := o.Flush()
+			if err != nil {
+				o.logger.Error("发件箱消息发送失败", zap.Error(err))
+				break
+			}
+			if n < o.options.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-exit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush 按写入顺序发送一批待发送的消息，返回已处理(发送或放弃)的数量。
+// 发送前先认领消息，多个中继同时运行时每条消息只由一个中继发送；
+// 遇到发送失败时会停止本批次以保持消息顺序，已认领的消息在下次 Flush 时重试。
+// 发送次数达到 MaxAttempts 的消息转入死信主题(如已设置)并标记为失败，不再阻塞后续消息。
+func (o *Outbox) Flush() (int, error) {
+	pending, err := o.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, row := range pending {
+		msg := &broker.Message{Header: make(map[string]string), Body: row.Body}
+		if len(row.Header) > 0 {
+			if err := json.Unmarshal(row.Header, &msg.Header); err != nil {
+				return i, err
+			}
+		}
+		if msg.Header == nil {
+			msg.Header = make(map[string]string)
+		}
+		msg.Header[HeaderMessageID] = strconv.FormatUint(row.ID, 10)
+
+		if err := o.broker.Publish(row.Topic, msg); err != nil {
+			if row.Attempts+1 < o.options.MaxAttempts {
+				if uerr := o.exec("UPDATE %s SET attempts = attempts + 1, last_error = ? WHERE id = ?",
+					truncate(err.Error(), 1024),
+					row.ID); uerr != nil {
+					o.logger.Error("更新发件箱消息状态失败", zap.Error(uerr))
+				}
+				return i, err
+			}
+			if derr := o.giveUp(row, msg, err); derr != nil {
+				return i, derr
+			}
+			continue
+		}
+
+		// 发送成功但标记失败时消息会被再次发送(至少一次)
+		if err := o.exec("UPDATE %s SET sent = ?, sent_at = ?, attempts = attempts + 1 WHERE id = ?",
+			true,
+			time.Now(),
+			row.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(pending), nil
+}
+
+// giveUp 将多次发送失败的消息转入死信主题并标记为失败
+func (o *Outbox) giveUp(row Message, msg *broker.Message, cause error) error {
+	if len(o.options.DeadLetter) > 0 {
+		msg.Header[HeaderOriginalTopic] = row.Topic
+		msg.Header[HeaderLastError] = cause.Error()
+		if err := o.broker.Publish(o.options.DeadLetter, msg); err != nil {
+			return fmt.Errorf("发送至死信主题%s失败: %v (原错误: %w)", o.options.DeadLetter, err, cause)
+		}
+	}
+
+	o.logger.Error("发件箱消息多次发送失败，已放弃发送",
+		zap.Uint64("id", row.ID),
+		zap.String("topic", row.Topic),
+		zap.Error(cause))
+	return o.exec("UPDATE %s SET failed = ?, attempts = attempts + 1, last_error = ? WHERE id = ?",
+		true,
+		truncate(cause.Error(), 1024),
+		row.ID)
+}
+
+// exec 对发件箱数据表执行SQL语句，语句中的 %s 替换为数据表名
+func (o *Outbox) exec(sql string, params ...interface{}) error {
+	return o.repos.Exec(fmt.Sprintf(sql, o.options.Table), params...)
+}
+
+// claim 认领一批未发送且未被其他中继认领(或认领已过期)的消息并返回
+func (o *Outbox) claim() ([]Message, error) {
+	now := time.Now().UTC()
+	until := now.Add(o.options.ClaimTimeout)
+
+	// 子查询包裹在派生表中，以兼容不允许在 UPDATE 中直接查询目标表的数据库
+	cond := "sent = ? AND failed = ? AND (claimed_until IS NULL OR claimed_until < ? OR claimed_by = ?)"
+	if err := o.repos.Exec(fmt.Sprintf(
+		"UPDATE %[1]s SET claimed_by = ?, claimed_until = ? WHERE %[2]s AND id IN "+
+			"(SELECT id FROM (SELECT id FROM %[1]s WHERE %[2]s ORDER BY id LIMIT ?) AS claimable)", o.options.Table, cond),
+		o.id, until,
+		false, false, now, o.id,
+		false, false, now, o.id,
+		o.options.BatchSize); err != nil {
+		return nil, err
+	}
+
+	var pending []Message
+	if err := o.repos.Raw(&pending,
+		fmt.Sprintf("SELECT * FROM %s WHERE claimed_by = ? AND sent = ? AND failed = ? ORDER BY id LIMIT ?", o.options.Table),
+		o.id,
+		false,
+		false,
+		o.options.BatchSize); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
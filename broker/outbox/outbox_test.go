@@ -0,0 +1,296 @@
+package outbox
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+	"github.com/dotnetage/go-titan/repository"
+
+	"github.com/stretchr/testify/require"
+)
+
+type orderTest struct {
+	ID    uint `gorm:"primaryKey"`
+	Total float64
+}
+
+type failingBroker struct {
+	broker.Broker
+}
+
+func (b *failingBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	return errors.New("broker unavailable")
+}
+
+// topicFailingBroker fails every publish to topic
+type topicFailingBroker struct {
+	broker.Broker
+	topic string
+}
+
+func (b *topicFailingBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	if topic == b.topic {
+		return errors.New("broker unavailable")
+	}
+	return b.Broker.Publish(topic, m, opts...)
+}
+
+// blockingBroker holds every publish until release is closed
+type blockingBroker struct {
+	broker.Broker
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	b.once.Do(func() { close(b.started) })
+	<-b.release
+	return b.Broker.Publish(topic, m, opts...)
+}
+
+func newTestRepos(t *testing.T) repository.Repository {
+	repos := repository.New(repository.WithSQLite(filepath.Join(t.TempDir(), "outbox.db")))
+	require.NoError(t, repos.Setup(&orderTest{}))
+	return repos
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("CommitAndRelay", func(t *testing.T) {
+		repos := newTestRepos(t)
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			mu       sync.Mutex
+			received []*broker.Message
+		)
+		_, err := b.Subscribe("orders.created", func(e broker.Event) error {
+			mu.Lock()
+			received = append(received, e.Message())
+			mu.Unlock()
+			return nil
+		})
+		require.NoError(t, err)
+
+		box := New(repos, b, Interval(10*time.Millisecond))
+		require.NoError(t, box.Setup())
+
+		err = repos.Transaction(func(tx repository.Repository) error {
+			if err := tx.Add(&orderTest{Total: 12.5}); err != nil {
+				return err
+			}
+			return Enqueue(tx, "orders.created", &broker.Message{
+				Header: map[string]string{"Content-Type": "application/json"},
+				Body:   []byte(`{"total":12.5}`),
+			})
+		})
+		require.NoError(t, err)
+
+		box.Start()
+		defer box.Stop()
+
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) == 1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		mu.Lock()
+		require.Equal(t, `{"total":12.5}`, string(received[0].Body))
+		require.Equal(t, "application/json", received[0].Header["Content-Type"])
+		require.Equal(t, "1", received[0].Header[HeaderMessageID])
+		mu.Unlock()
+
+		require.Eventually(t, func() bool {
+			var sent []Message
+			_, err := repos.Query(&sent, "sent = ?", true)
+			return err == nil && len(sent) == 1
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("RollbackDropsMessage", func(t *testing.T) {
+		repos := newTestRepos(t)
+		box := New(repos, memory.NewBroker())
+		require.NoError(t, box.Setup())
+
+		err := repos.Transaction(func(tx repository.Repository) error {
+			if err := Enqueue(tx, "orders.created", &broker.Message{Body: []byte("order")}); err != nil {
+				return err
+			}
+			return errors.New("business rule violated")
+		})
+		require.Error(t, err)
+
+		count, err := repos.Count(&Message{})
+		require.NoError(t, err)
+		require.Equal(t, 0, count)
+	})
+
+	t.Run("PublishFailureKeepsPending", func(t *testing.T) {
+		repos := newTestRepos(t)
+		box := New(repos, &failingBroker{})
+		require.NoError(t, box.Setup())
+
+		require.NoError(t, Enqueue(repos, "orders.created", &broker.Message{Body: []byte("order")}))
+
+		n, err := box.Flush()
+		require.Error(t, err)
+		require.Equal(t, 0, n)
+
+		var pending []Message
+		_, err = repos.Query(&pending, "sent = ?", false)
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		require.Equal(t, 1, pending[0].Attempts)
+		require.Equal(t, "broker unavailable", pending[0].LastError)
+	})
+
+	t.Run("ClaimedByOneRelay", func(t *testing.T) {
+		repos := newTestRepos(t)
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			mu  sync.Mutex
+			ids []string
+		)
+		_, err := b.Subscribe("orders.created", func(e broker.Event) error {
+			mu.Lock()
+			ids = append(ids, e.Message().Header[HeaderMessageID])
+			mu.Unlock()
+			return nil
+		})
+		require.NoError(t, err)
+
+		blocking := &blockingBroker{Broker: b, started: make(chan struct{}), release: make(chan struct{})}
+		first := New(repos, blocking)
+		second := New(repos, b)
+		require.NoError(t, first.Setup())
+		for i := 0; i < 3; i++ {
+			require.NoError(t, Enqueue(repos, "orders.created", &broker.Message{}))
+		}
+
+		done := make(chan int)
+		go func() {
+			n, _ := first.Flush()
+			done <- n
+		}()
+		<-blocking.started
+
+		// 消息已被第一个中继认领
+		n, err := second.Flush()
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		close(blocking.release)
+		require.Equal(t, 3, <-done)
+		require.Equal(t, []string{"1", "2", "3"}, ids)
+	})
+
+	t.Run("ExpiredClaim", func(t *testing.T) {
+		repos := newTestRepos(t)
+		box := New(repos, &failingBroker{}, ClaimTimeout(time.Millisecond))
+		require.NoError(t, box.Setup())
+		require.NoError(t, Enqueue(repos, "orders.created", &broker.Message{}))
+
+		_, err := box.Flush()
+		require.Error(t, err)
+		<-time.After(10 * time.Millisecond)
+
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+		n, err := New(repos, b).Flush()
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("SeparateTables", func(t *testing.T) {
+		repos := newTestRepos(t)
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		orders := New(repos, b, Table("order_outbox"))
+		billing := New(repos, b, Table("billing_outbox"))
+		require.NoError(t, orders.Setup())
+		require.NoError(t, billing.Setup())
+
+		require.NoError(t, orders.Enqueue(repos, "orders.created", &broker.Message{}))
+		require.NoError(t, orders.Enqueue(repos, "orders.created", &broker.Message{}))
+		require.NoError(t, billing.Enqueue(repos, "invoices.created", &broker.Message{}))
+
+		n, err := orders.Flush()
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+		n, err = billing.Flush()
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	})
+
+	t.Run("DeadLetterAfterMaxAttempts", func(t *testing.T) {
+		repos := newTestRepos(t)
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			mu       sync.Mutex
+			dead     []*broker.Message
+			received []*broker.Message
+		)
+		_, err := b.Subscribe("outbox.dlq", func(e broker.Event) error {
+			mu.Lock()
+			dead = append(dead, e.Message())
+			mu.Unlock()
+			return nil
+		})
+		require.NoError(t, err)
+		_, err = b.Subscribe("orders.created", func(e broker.Event) error {
+			mu.Lock()
+			received = append(received, e.Message())
+			mu.Unlock()
+			return nil
+		})
+		require.NoError(t, err)
+
+		box := New(repos, &topicFailingBroker{Broker: b, topic: "orders.broken"}, MaxAttempts(2), DeadLetter("outbox.dlq"))
+		require.NoError(t, box.Setup())
+		require.NoError(t, Enqueue(repos, "orders.broken", &broker.Message{Body: []byte("broken")}))
+		require.NoError(t, Enqueue(repos, "orders.created", &broker.Message{Body: []byte("order")}))
+
+		// the failing head message blocks the batch until it is given up on
+		n, err := box.Flush()
+		require.Error(t, err)
+		require.Equal(t, 0, n)
+		n, err = box.Flush()
+		require.NoError(t, err)
+		require.Equal(t, 2, n)
+
+		mu.Lock()
+		require.Len(t, dead, 1)
+		require.Equal(t, "broken", string(dead[0].Body))
+		require.Equal(t, "orders.broken", dead[0].Header[HeaderOriginalTopic])
+		require.Equal(t, "broker unavailable", dead[0].Header[HeaderLastError])
+		require.Len(t, received, 1)
+		mu.Unlock()
+
+		var failed []Message
+		_, err = repos.Query(&failed, "failed = ?", true)
+		require.NoError(t, err)
+		require.Len(t, failed, 1)
+		require.Equal(t, 2, failed[0].Attempts)
+
+		n, err = box.Flush()
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+	})
+}
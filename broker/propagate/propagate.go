@@ -0,0 +1,229 @@
+// Package propagate carries the authenticated principal and the trace context
+// of the publisher to the subscribers of a message. The values are read from
+// the context given with broker.PublishContext, written to Message.Header and
+// restored into the context handed to the subscriber.
+//
+// Any client of the broker can write any header, so the principal is only
+// carried as a token issued and verified by the auth.Tokens set with the
+// Tokens option. Without it the principal is not propagated at all, and a
+// principal header that fails verification is dropped.
+package propagate
+
+import (
+	"context"
+
+	"github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/broker"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/propagation/b3"
+)
+
+// HeaderPrincipal holds the access token of the publishing auth.Principal
+const HeaderPrincipal = "X-Principal"
+
+// Options of the propagating broker
+type Options struct {
+	// Tracer records a producer span for every publish and a consumer span
+	// for every delivery, the trace context is propagated without it as well
+	Tracer *zipkin.Tracer
+
+	// Tokens signs the principal of the publisher and verifies it on the
+	// subscriber side, both sides must share the same signing key
+	Tokens auth.Tokens
+}
+
+// Option sets a value of Options
+type Option func(*Options)
+
+// Tracer sets the zipkin tracer used to record producer and consumer spans
+func Tracer(t *zipkin.Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = t
+	}
+}
+
+// Tokens sets the token issuer used to sign and verify the principal header
+func Tokens(t auth.Tokens) Option {
+	return func(o *Options) {
+		o.Tokens = t
+	}
+}
+
+func newOptions(opts ...Option) Options {
+	options := Options{}
+	for _, o := range opts {
+		o(&options)
+	}
+	return options
+}
+
+// ContextHandler is a broker.Handler that receives the restored context
+type ContextHandler func(ctx context.Context, e broker.Event) error
+
+type propagateBroker struct {
+	broker.Broker
+	opts Options
+}
+
+// NewBroker wraps b so that published messages carry the principal and the
+// trace context of broker.PublishContext, and subscribers can get them back
+// with FromEvent
+func NewBroker(b broker.Broker, opts ...Option) broker.Broker {
+	return &propagateBroker{Broker: b, opts: newOptions(opts...)}
+}
+
+func (b *propagateBroker) Publish(topic string, m *broker.Message, opts ...broker.PublishOption) error {
+	options := broker.NewPublishOptions(opts...)
+	ctx := options.Context
+
+	msg := &broker.Message{
+		Header: make(map[string]string, len(m.Header)+4),
+		Body:   m.Body,
+	}
+	for k, v := range m.Header {
+		msg.Header[k] = v
+	}
+
+	var span zipkin.Span
+	if b.opts.Tracer != nil {
+		span, ctx = b.opts.Tracer.StartSpanFromContext(ctx, "publish "+topic, zipkin.Kind(model.Producer))
+		span.Tag("broker.topic", topic)
+		defer span.Finish()
+	}
+
+	if err := inject(ctx, msg, b.opts); err != nil {
+		return err
+	}
+
+	err := b.Broker.Publish(topic, msg, opts...)
+	if err != nil && span != nil {
+		zipkin.TagError.Set(span, err.Error())
+	}
+	return err
+}
+
+func (b *propagateBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	options := broker.NewSubscribeOptions(opts...)
+	base := options.Context
+
+	return b.Broker.Subscribe(topic, func(e broker.Event) error {
+		ctx := extract(base, e.Message(), b.opts)
+
+		if b.opts.Tracer != nil {
+			spanOpts := []zipkin.SpanOption{zipkin.Kind(model.Consumer)}
+			if parent, ok := SpanContext(ctx); ok {
+				spanOpts = append(spanOpts, zipkin.Parent(parent))
+			}
+			span := b.opts.Tracer.StartSpan("receive "+topic, spanOpts...)
+			span.Tag("broker.topic", topic)
+			defer span.Finish()
+			ctx = zipkin.NewContext(ctx, span)
+
+			err := h(&contextEvent{Event: e, ctx: ctx})
+			if err != nil {
+				zipkin.TagError.Set(span, err.Error())
+			}
+			return err
+		}
+
+		return h(&contextEvent{Event: e, ctx: ctx})
+	}, opts...)
+}
+
+// Handler adapts fn to a broker.Handler, fn receives the context restored by
+// FromEvent
+func Handler(fn ContextHandler, opts ...Option) broker.Handler {
+	return func(e broker.Event) error {
+		return fn(FromEvent(e, opts...), e)
+	}
+}
+
+// FromEvent returns the context carrying the principal and the trace context
+// of the publisher. Events delivered by a broker created with NewBroker carry
+// the context already, for any other event it is extracted from the headers
+// and the principal is verified with the Tokens option.
+func FromEvent(e broker.Event, opts ...Option) context.Context {
+	if ce, ok := e.(*contextEvent); ok {
+		return ce.ctx
+	}
+	return Extract(context.Background(), e.Message(), opts...)
+}
+
+// Inject writes the principal and the trace context of ctx to the headers of
+// m, the principal is written only when the Tokens option is given
+func Inject(ctx context.Context, m *broker.Message, opts ...Option) error {
+	return inject(ctx, m, newOptions(opts...))
+}
+
+func inject(ctx context.Context, m *broker.Message, options Options) error {
+	if m.Header == nil {
+		m.Header = make(map[string]string)
+	}
+
+	// never forward a principal header that does not come from ctx
+	delete(m.Header, HeaderPrincipal)
+	if user, ok := auth.AuthUser(ctx); ok && user != nil && options.Tokens != nil {
+		token, err := options.Tokens.Generate(user)
+		if err != nil {
+			return err
+		}
+		m.Header[HeaderPrincipal] = token.AccessToken
+	}
+
+	sc, ok := SpanContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	carrier := b3.Map(m.Header)
+	return carrier.Inject()(sc)
+}
+
+// Extract returns a copy of ctx with the principal and the trace context
+// found in the headers of m. Headers that are missing or malformed are ignored,
+// the principal is restored only when it passes verification by the Tokens
+// option.
+func Extract(ctx context.Context, m *broker.Message, opts ...Option) context.Context {
+	return extract(ctx, m, newOptions(opts...))
+}
+
+func extract(ctx context.Context, m *broker.Message, options Options) context.Context {
+	if m == nil || len(m.Header) == 0 {
+		return ctx
+	}
+
+	if v, ok := m.Header[HeaderPrincipal]; ok && options.Tokens != nil {
+		if user, err := options.Tokens.Inspect(v); err == nil && user != nil {
+			ctx = auth.ContextWithUser(ctx, user)
+		}
+	}
+
+	carrier := b3.Map(m.Header)
+	if sc, err := carrier.Extract(); err == nil && sc != nil && !sc.TraceID.Empty() {
+		ctx = context.WithValue(ctx, spanContextKey{}, *sc)
+	}
+
+	return ctx
+}
+
+// SpanContext returns the trace context restored by Extract
+func SpanContext(ctx context.Context) (model.SpanContext, bool) {
+	if span := zipkin.SpanFromContext(ctx); span != nil {
+		return span.Context(), true
+	}
+	return spanContextFrom(ctx)
+}
+
+type spanContextKey struct{}
+
+func spanContextFrom(ctx context.Context) (model.SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(model.SpanContext)
+	return sc, ok
+}
+
+type contextEvent struct {
+	broker.Event
+	ctx context.Context
+}
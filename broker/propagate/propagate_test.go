@@ -0,0 +1,139 @@
+package propagate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/auth/tokens/jwt"
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter/recorder"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPropagate(t *testing.T) {
+	user := auth.NewUser("ray", "orders:write").SetRoles("admin")
+	user.Name = "ray"
+	tokens := jwt.New(jwt.SignWithHS256())
+
+	t.Run("PrincipalAndTrace", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker(), Tokens(tokens))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			got   *auth.Principal
+			trace model.SpanContext
+		)
+		_, err := b.Subscribe("orders.created", Handler(func(ctx context.Context, e broker.Event) error {
+			got, _ = auth.AuthUser(ctx)
+			trace, _ = SpanContext(ctx)
+			return nil
+		}))
+		require.NoError(t, err)
+
+		sc := model.SpanContext{TraceID: model.TraceID{Low: 42}, ID: model.ID(7)}
+		ctx := auth.ContextWithUser(context.Background(), user)
+		ctx = context.WithValue(ctx, spanContextKey{}, sc)
+
+		require.NoError(t, b.Publish("orders.created", &broker.Message{Body: []byte("order")}, broker.PublishContext(ctx)))
+
+		require.NotNil(t, got)
+		require.Equal(t, user.ID, got.ID)
+		require.Equal(t, "ray", got.Name)
+		require.Equal(t, []string{"admin"}, got.Roles)
+		require.Equal(t, sc.TraceID, trace.TraceID)
+		require.Equal(t, sc.ID, trace.ID)
+	})
+
+	t.Run("Tracer", func(t *testing.T) {
+		rec := recorder.NewReporter()
+		defer rec.Close()
+		tracer, err := zipkin.NewTracer(rec)
+		require.NoError(t, err)
+
+		b := NewBroker(memory.NewBroker(), Tracer(tracer))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err = b.Subscribe("orders.created", func(e broker.Event) error {
+			return nil
+		})
+		require.NoError(t, err)
+
+		root := tracer.StartSpan("handler")
+		ctx := zipkin.NewContext(context.Background(), root)
+		require.NoError(t, b.Publish("orders.created", &broker.Message{}, broker.PublishContext(ctx)))
+		root.Finish()
+
+		spans := rec.Flush()
+		require.Len(t, spans, 3)
+		for _, s := range spans {
+			require.Equal(t, root.Context().TraceID, s.TraceID)
+		}
+	})
+
+	t.Run("PlainSubscriber", func(t *testing.T) {
+		b := memory.NewBroker()
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var got *auth.Principal
+		_, err := b.Subscribe("orders.created", Handler(func(ctx context.Context, e broker.Event) error {
+			got, _ = auth.AuthUser(ctx)
+			return nil
+		}, Tokens(tokens)))
+		require.NoError(t, err)
+
+		msg := &broker.Message{}
+		require.NoError(t, Inject(auth.ContextWithUser(context.Background(), user), msg, Tokens(tokens)))
+		require.NoError(t, b.Publish("orders.created", msg))
+
+		require.NotNil(t, got)
+		require.Equal(t, user.ID, got.ID)
+	})
+
+	t.Run("ForgedPrincipal", func(t *testing.T) {
+		inner := memory.NewBroker()
+		b := NewBroker(inner, Tokens(tokens))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var (
+			got    *auth.Principal
+			called bool
+		)
+		_, err := b.Subscribe("orders.created", Handler(func(ctx context.Context, e broker.Event) error {
+			called = true
+			got, _ = auth.AuthUser(ctx)
+			return nil
+		}))
+		require.NoError(t, err)
+
+		// a token signed with another key, and a bare principal as written
+		// by a client outside the wrapper
+		other := jwt.New(jwt.SignWithHS256())
+		forged, err := other.Generate(user)
+		require.NoError(t, err)
+
+		for _, header := range []string{forged.AccessToken, `{"id":"1","roles":["admin"]}`} {
+			called, got = false, nil
+			require.NoError(t, inner.Publish("orders.created", &broker.Message{Header: map[string]string{HeaderPrincipal: header}}))
+			require.True(t, called)
+			require.Nil(t, got)
+		}
+
+		msg := &broker.Message{Header: map[string]string{HeaderPrincipal: forged.AccessToken}}
+		require.Nil(t, principalOf(Extract(context.Background(), msg, Tokens(tokens))))
+		require.Nil(t, principalOf(Extract(context.Background(), msg)))
+	})
+}
+
+func principalOf(ctx context.Context) *auth.Principal {
+	user, _ := auth.AuthUser(ctx)
+	return user
+}
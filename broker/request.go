@@ -0,0 +1,115 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// HeaderReplyTo names the topic the response of a request is published to
+	HeaderReplyTo = "Reply-To"
+	// HeaderCorrelationID pairs a response with its request
+	HeaderCorrelationID = "Correlation-Id"
+	// HeaderError carries the error returned by the responder
+	HeaderError = "X-Error"
+
+	inboxPrefix = "_INBOX."
+)
+
+var (
+	// ErrRequestTimeout is returned by Request when no response arrived in time
+	ErrRequestTimeout = errors.New("broker: request timed out")
+	// ErrRequestFailed wraps the error reported by the responder
+	ErrRequestFailed = errors.New("broker: request failed")
+	// ErrMissingReplyTo is reported by Respond for messages without a reply topic
+	ErrMissingReplyTo = errors.New("broker: message has no reply topic")
+)
+
+// ReplyHandler processes a request and returns the response message
+type ReplyHandler func(req *Message) (*Message, error)
+
+// Request publishes m to topic and waits for the correlated response.
+// The response is received on an ephemeral subscription that is removed
+// before Request returns.
+func Request(b Broker, topic string, m *Message, timeout time.Duration, opts ...PublishOption) (*Message, error) {
+	id := uuid.NewV4().String()
+	inbox := inboxPrefix + id
+
+	replies := make(chan *Message, 1)
+	sub, err := b.Subscribe(inbox, func(e Event) error {
+		msg := e.Message()
+		if msg.Header[HeaderCorrelationID] != id {
+			return nil
+		}
+		select {
+		case replies <- msg:
+		default:
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	req := &Message{
+		Header: make(map[string]string, len(m.Header)+2),
+		Body:   m.Body,
+	}
+	for k, v := range m.Header {
+		req.Header[k] = v
+	}
+	req.Header[HeaderReplyTo] = inbox
+	req.Header[HeaderCorrelationID] = id
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	if err := b.Publish(topic, req, opts...); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-replies:
+		if msg, ok := resp.Header[HeaderError]; ok {
+			return resp, fmt.Errorf("%w: %s", ErrRequestFailed, msg)
+		}
+		return resp, nil
+	case <-timer.C:
+		return nil, ErrRequestTimeout
+	}
+}
+
+// Respond subscribes fn to topic and publishes its result to the reply
+// topic of every request. Errors returned by fn are sent back in HeaderError.
+func Respond(b Broker, topic string, fn ReplyHandler, opts ...SubscribeOption) (Subscriber, error) {
+	return b.Subscribe(topic, func(e Event) error {
+		req := e.Message()
+		replyTo := req.Header[HeaderReplyTo]
+		if len(replyTo) == 0 {
+			return ErrMissingReplyTo
+		}
+
+		resp, err := fn(req)
+		if resp == nil {
+			resp = &Message{}
+		}
+
+		reply := &Message{
+			Header: make(map[string]string, len(resp.Header)+2),
+			Body:   resp.Body,
+		}
+		for k, v := range resp.Header {
+			reply.Header[k] = v
+		}
+		reply.Header[HeaderCorrelationID] = req.Header[HeaderCorrelationID]
+		if err != nil {
+			reply.Header[HeaderError] = err.Error()
+		}
+
+		return b.Publish(replyTo, reply)
+	}, opts...)
+}
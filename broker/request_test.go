@@ -0,0 +1,52 @@
+package broker_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequest(t *testing.T) {
+	b := memory.NewBroker()
+	require.NoError(t, b.Connect())
+	defer b.Disconnect()
+
+	_, err := broker.Respond(b, "greeter", func(req *broker.Message) (*broker.Message, error) {
+		name := string(req.Body)
+		if name == "" {
+			return nil, errors.New("name is required")
+		}
+		return &broker.Message{
+			Header: map[string]string{"Lang": req.Header["Lang"]},
+			Body:   []byte("hello " + strings.ToUpper(name)),
+		}, nil
+	}, broker.Queue("greeters"))
+	require.NoError(t, err)
+
+	t.Run("Reply", func(t *testing.T) {
+		resp, err := broker.Request(b, "greeter", &broker.Message{
+			Header: map[string]string{"Lang": "en"},
+			Body:   []byte("titan"),
+		}, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "hello TITAN", string(resp.Body))
+		require.Equal(t, "en", resp.Header["Lang"])
+	})
+
+	t.Run("RemoteError", func(t *testing.T) {
+		_, err := broker.Request(b, "greeter", &broker.Message{}, time.Second)
+		require.ErrorIs(t, err, broker.ErrRequestFailed)
+		require.Contains(t, err.Error(), "name is required")
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		_, err := broker.Request(b, "nobody", &broker.Message{}, 20*time.Millisecond)
+		require.ErrorIs(t, err, broker.ErrRequestTimeout)
+	})
+}
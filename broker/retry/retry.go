@@ -0,0 +1,234 @@
+// Package retry wraps any broker.Broker so that failing handlers are retried
+// with exponential backoff and finally moved to a dead-letter topic.
+//
+// A failed message is published again with broker.DeliverAfter to a retry
+// topic only the failing subscription listens on, so the handler does not
+// block while it waits. The wrapped broker should support delayed delivery,
+// otherwise retries happen right away.
+//
+// The retry topic is derived from the topic and the queue group, or for plain
+// subscriptions from the SubscriberName option, so that a subscription created
+// again after a restart picks up the retries still pending.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+)
+
+const (
+	// HeaderAttempts holds the number of failed attempts, it is set on
+	// retried and dead-lettered messages
+	HeaderAttempts = "X-Retry-Attempts"
+	// HeaderFailureReason holds the error returned by the last attempt
+	HeaderFailureReason = "X-Failure-Reason"
+	// HeaderOriginalTopic holds the topic the dead-lettered message was published to
+	HeaderOriginalTopic = "X-Original-Topic"
+)
+
+// ErrSubscriberName is returned by Subscribe for a subscription that has
+// neither a queue group nor a SubscriberName
+var ErrSubscriberName = errors.New("retry: subscriber name or queue required")
+
+var (
+	// DefaultMaxAttempts is the number of attempts made when MaxAttempts is not set
+	DefaultMaxAttempts = 3
+	// DefaultBackoff is the delay before the first retry
+	DefaultBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff caps the delay between two attempts
+	DefaultMaxBackoff = 10 * time.Second
+)
+
+type maxAttemptsKey struct{}
+type backoffKey struct{}
+type deadLetterKey struct{}
+type subscriberNameKey struct{}
+
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+type policy struct {
+	maxAttempts int
+	backoff     backoff
+	deadLetter  string
+}
+
+type retryBroker struct {
+	broker.Broker
+}
+
+// NewBroker wraps b so that its subscriptions honour MaxAttempts,
+// Backoff and DeadLetter
+func NewBroker(b broker.Broker) broker.Broker {
+	return &retryBroker{Broker: b}
+}
+
+// MaxAttempts sets how many times the handler is called before the message
+// is given up on
+func MaxAttempts(n int) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, maxAttemptsKey{}, n)
+	}
+}
+
+// Backoff sets the delay before the first retry, doubled after every failed
+// attempt up to max
+func Backoff(initial, max time.Duration) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, backoffKey{}, backoff{initial: initial, max: max})
+	}
+}
+
+// DeadLetter publishes messages that still fail after the last attempt to topic
+func DeadLetter(topic string) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, deadLetterKey{}, topic)
+	}
+}
+
+// SubscriberName names a plain subscription, its retries are published to
+// a topic derived from the subscribed topic and name. Subscriptions with a
+// queue group use the group instead.
+func SubscriberName(name string) broker.SubscribeOption {
+	return func(o *broker.SubscribeOptions) {
+		o.Context = context.WithValue(o.Context, subscriberNameKey{}, name)
+	}
+}
+
+type retrySubscriber struct {
+	broker.Subscriber
+	retry broker.Subscriber
+}
+
+// retryEvent reports the original topic for messages delivered from the retry topic
+type retryEvent struct {
+	broker.Event
+	topic string
+}
+
+func (r *retryBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	options := broker.NewSubscribeOptions(opts...)
+	p := newPolicy(options)
+
+	// 队列订阅的成员共用重试主题，普通订阅按名称使用各自的重试主题
+	name := options.Queue
+	if len(name) == 0 && options.Context != nil {
+		name, _ = options.Context.Value(subscriberNameKey{}).(string)
+	}
+	if len(name) == 0 {
+		return nil, ErrSubscriberName
+	}
+	retryTopic := topic + ".retry." + name
+
+	handler := r.wrap(topic, retryTopic, h, p)
+	retry, err := r.Broker.Subscribe(retryTopic, func(e broker.Event) error {
+		return handler(&retryEvent{Event: e, topic: topic})
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := r.Broker.Subscribe(topic, handler, opts...)
+	if err != nil {
+		_ = retry.Unsubscribe()
+		return nil, err
+	}
+	return &retrySubscriber{Subscriber: sub, retry: retry}, nil
+}
+
+func (r *retryBroker) wrap(topic, retryTopic string, h broker.Handler, p policy) broker.Handler {
+	return func(e broker.Event) error {
+		err := h(e)
+		if err == nil {
+			return nil
+		}
+
+		msg := e.Message()
+		attempt, _ := strconv.Atoi(msg.Header[HeaderAttempts])
+		attempt++
+
+		next := &broker.Message{
+			Header: make(map[string]string, len(msg.Header)+3),
+			Body:   msg.Body,
+		}
+		for k, v := range msg.Header {
+			next.Header[k] = v
+		}
+		next.Header[HeaderAttempts] = strconv.Itoa(attempt)
+		next.Header[HeaderFailureReason] = err.Error()
+
+		if attempt < p.maxAttempts {
+			if perr := r.Broker.Publish(retryTopic, next, broker.DeliverAfter(p.delay(attempt))); perr != nil {
+				return fmt.Errorf("schedule retry on %s: %v (handler error: %w)", retryTopic, perr, err)
+			}
+			// 已安排重试，确认本次投递
+			return e.Ack()
+		}
+
+		if len(p.deadLetter) == 0 {
+			return err
+		}
+
+		next.Header[HeaderOriginalTopic] = topic
+		if perr := r.Broker.Publish(p.deadLetter, next); perr != nil {
+			return fmt.Errorf("publish to dead letter topic %s: %v (handler error: %w)", p.deadLetter, perr, err)
+		}
+
+		// 消息已转入死信队列，确认原消息以免重复投递
+		return e.Ack()
+	}
+}
+
+func newPolicy(o broker.SubscribeOptions) policy {
+	p := policy{
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     backoff{initial: DefaultBackoff, max: DefaultMaxBackoff},
+	}
+	if o.Context == nil {
+		return p
+	}
+	if n, ok := o.Context.Value(maxAttemptsKey{}).(int); ok && n > 0 {
+		p.maxAttempts = n
+	}
+	if b, ok := o.Context.Value(backoffKey{}).(backoff); ok {
+		p.backoff = b
+	}
+	if t, ok := o.Context.Value(deadLetterKey{}).(string); ok {
+		p.deadLetter = t
+	}
+	return p
+}
+
+// delay returns the wait before the attempt following the given one
+func (p policy) delay(attempt int) time.Duration {
+	d := p.backoff.initial
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.backoff.max > 0 && d >= p.backoff.max {
+			return p.backoff.max
+		}
+	}
+	if p.backoff.max > 0 && d > p.backoff.max {
+		return p.backoff.max
+	}
+	return d
+}
+
+func (s *retrySubscriber) Unsubscribe() error {
+	err := s.Subscriber.Unsubscribe()
+	if rerr := s.retry.Unsubscribe(); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func (e *retryEvent) Topic() string {
+	return e.topic
+}
@@ -0,0 +1,209 @@
+package retry
+
+import (
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, ch <-chan broker.Event) broker.Event {
+	select {
+	case e := <-ch:
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("message was not delivered")
+		return nil
+	}
+}
+
+func TestRetryBroker(t *testing.T) {
+	t.Run("SucceedsAfterRetry", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		ch := make(chan broker.Event, 3)
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			ch <- e
+			if len(ch) < 3 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		}, SubscriberName("billing"), MaxAttempts(3), Backoff(time.Millisecond, 5*time.Millisecond))
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{Body: []byte("order")}))
+
+		first := receive(t, ch)
+		require.Empty(t, first.Message().Header[HeaderAttempts])
+		for i := 1; i < 3; i++ {
+			e := receive(t, ch)
+			require.Equal(t, "orders", e.Topic())
+			require.Equal(t, "order", string(e.Message().Body))
+			require.Equal(t, strconv.Itoa(i), e.Message().Header[HeaderAttempts])
+			require.Equal(t, "temporary failure", e.Message().Header[HeaderFailureReason])
+		}
+	})
+
+	t.Run("PublishDoesNotWaitForBackoff", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			return errors.New("temporary failure")
+		}, SubscriberName("billing"), MaxAttempts(3), Backoff(time.Minute, time.Minute))
+		require.NoError(t, err)
+
+		start := time.Now()
+		require.NoError(t, b.Publish("orders", &broker.Message{}))
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+
+	t.Run("RetryOnlyFailingSubscriber", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var healthy int32
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			atomic.AddInt32(&healthy, 1)
+			return nil
+		}, SubscriberName("audit"))
+		require.NoError(t, err)
+
+		retried := make(chan broker.Event, 2)
+		_, err = b.Subscribe("orders", func(e broker.Event) error {
+			retried <- e
+			if len(retried) < 2 {
+				return errors.New("temporary failure")
+			}
+			return nil
+		}, SubscriberName("billing"), Backoff(time.Millisecond, time.Millisecond))
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{}))
+		receive(t, retried)
+		require.Equal(t, "1", receive(t, retried).Message().Header[HeaderAttempts])
+		require.Equal(t, int32(1), atomic.LoadInt32(&healthy))
+	})
+
+	t.Run("DeadLetter", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		dead := make(chan broker.Event, 1)
+		_, err := b.Subscribe("orders.dlq", func(e broker.Event) error {
+			dead <- e
+			return nil
+		}, SubscriberName("ops"))
+		require.NoError(t, err)
+
+		var attempts int32
+		_, err = b.Subscribe("orders", func(e broker.Event) error {
+			atomic.AddInt32(&attempts, 1)
+			return errors.New("invalid order")
+		}, broker.Queue("billing"), MaxAttempts(2), Backoff(time.Millisecond, time.Millisecond), DeadLetter("orders.dlq"))
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{
+			Header: map[string]string{"Id": "42"},
+			Body:   []byte("order"),
+		}))
+
+		msg := receive(t, dead).Message()
+		require.Equal(t, int32(2), atomic.LoadInt32(&attempts))
+		require.Equal(t, "order", string(msg.Body))
+		require.Equal(t, "42", msg.Header["Id"])
+		require.Equal(t, "2", msg.Header[HeaderAttempts])
+		require.Equal(t, "invalid order", msg.Header[HeaderFailureReason])
+		require.Equal(t, "orders", msg.Header[HeaderOriginalTopic])
+	})
+
+	t.Run("ErrorHandlerWithoutDeadLetter", func(t *testing.T) {
+		failed := make(chan broker.Event, 1)
+		b := NewBroker(memory.NewBroker(broker.ErrorHandler(func(e broker.Event) error {
+			failed <- e
+			return nil
+		})))
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := b.Subscribe("orders", func(e broker.Event) error {
+			return errors.New("boom")
+		}, SubscriberName("billing"), MaxAttempts(2), Backoff(time.Millisecond, time.Millisecond))
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{}))
+		require.EqualError(t, receive(t, failed).Error(), "boom")
+	})
+
+	t.Run("Unsubscribe", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		var attempts int32
+		sub, err := b.Subscribe("orders", func(e broker.Event) error {
+			atomic.AddInt32(&attempts, 1)
+			return errors.New("temporary failure")
+		}, SubscriberName("billing"), Backoff(20*time.Millisecond, 20*time.Millisecond))
+		require.NoError(t, err)
+
+		require.NoError(t, b.Publish("orders", &broker.Message{}))
+		require.NoError(t, sub.Unsubscribe())
+		<-time.After(50 * time.Millisecond)
+		require.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+	})
+
+	t.Run("SubscriberNameRequired", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		_, err := b.Subscribe("orders", func(e broker.Event) error { return nil })
+		require.ErrorIs(t, err, ErrSubscriberName)
+	})
+
+	t.Run("ResubscribeReceivesPendingRetry", func(t *testing.T) {
+		b := NewBroker(memory.NewBroker())
+		require.NoError(t, b.Connect())
+		defer b.Disconnect()
+
+		sub, err := b.Subscribe("orders", func(e broker.Event) error {
+			return errors.New("temporary failure")
+		}, SubscriberName("billing"), Backoff(50*time.Millisecond, 50*time.Millisecond))
+		require.NoError(t, err)
+		require.NoError(t, b.Publish("orders", &broker.Message{Body: []byte("order")}))
+		require.NoError(t, sub.Unsubscribe())
+
+		// the same name listens on the same retry topic after a restart
+		retried := make(chan broker.Event, 1)
+		_, err = b.Subscribe("orders", func(e broker.Event) error {
+			retried <- e
+			return nil
+		}, SubscriberName("billing"))
+		require.NoError(t, err)
+
+		e := receive(t, retried)
+		require.Equal(t, "order", string(e.Message().Body))
+		require.Equal(t, "1", e.Message().Header[HeaderAttempts])
+	})
+}
+
+func TestBackoffDelay(t *testing.T) {
+	p := policy{backoff: backoff{initial: 10 * time.Millisecond, max: 50 * time.Millisecond}}
+	require.Equal(t, 10*time.Millisecond, p.delay(1))
+	require.Equal(t, 20*time.Millisecond, p.delay(2))
+	require.Equal(t, 40*time.Millisecond, p.delay(3))
+	require.Equal(t, 50*time.Millisecond, p.delay(4))
+	require.Equal(t, 50*time.Millisecond, p.delay(10))
+}
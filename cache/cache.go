@@ -19,19 +19,26 @@ var (
 	// ErrKeyNotFound is returned in Cache.Get and Cache.Delete when the
 	// provided key could not be found in cache.
 	ErrKeyNotFound error = errors.New("key not found in cache")
+	// ErrItemTooLarge is returned in Cache.Put when the item alone exceeds
+	// the MaxBytes limit of the cache.
+	ErrItemTooLarge error = errors.New("item is larger than the cache")
 )
 
 // Cache is the interface that wraps the cache.
 //
 // Context specifies the context for the cache.
 // Get gets a cached value by key.
-// Put stores a key-value pair into cache.
+// Put stores a key-value pair into cache, optionally labelled with tags.
 // Delete removes a key from cache.
+// InvalidateTag removes all keys stored with the tag.
+// DeletePrefix removes all keys starting with the prefix.
 type Cache interface {
 	Context(ctx context.Context) Cache
 	Get(key string) (interface{}, time.Time, error)
-	Put(key string, val interface{}, d time.Duration) error
+	Put(key string, val interface{}, d time.Duration, tags ...string) error
 	Delete(key string) error
+	InvalidateTag(tag string) error
+	DeletePrefix(prefix string) error
 }
 
 // Item represents an item stored in the cache.
@@ -49,17 +56,12 @@ func (i *Item) Expired() bool {
 	return time.Now().UnixNano() > i.Expiration
 }
 
-// NewCache returns a new cache.
+// NewCache returns a new in-memory cache.
+//
+// The cache is unbounded unless MaxEntries or MaxBytes is set. When
+// CleanupInterval is set a janitor removes expired items in the background.
+// With Snapshot the items survive restarts. The background work is stopped by
+// closing the cache through io.Closer.
 func NewCache(opts ...Option) Cache {
-	options := NewOptions(opts...)
-	items := make(map[string]Item)
-
-	if len(options.Items) > 0 {
-		items = options.Items
-	}
-
-	return &memCache{
-		opts:  options,
-		items: items,
-	}
+	return newMemCache(NewOptions(opts...))
 }
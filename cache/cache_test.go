@@ -1,7 +1,17 @@
 package cache
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -109,3 +119,298 @@ func TestCacheWithOptions(t *testing.T) {
 		}
 	})
 }
+
+func TestCacheEviction(t *testing.T) {
+	t.Run("LRU", func(t *testing.T) {
+		c := NewCache(MaxEntries(2))
+
+		c.Put("a", 1, 0)
+		c.Put("b", 2, 0)
+		c.Get("a")
+		c.Put("c", 3, 0)
+
+		if _, _, err := c.Get("b"); err != ErrKeyNotFound {
+			t.Errorf("expected the least recently used item to be evicted, got err: %v", err)
+		}
+		for _, k := range []string{"a", "c"} {
+			if _, _, err := c.Get(k); err != nil {
+				t.Errorf("expected '%s' to be kept, got err: %s", k, err)
+			}
+		}
+	})
+
+	t.Run("LFU", func(t *testing.T) {
+		c := NewCache(MaxEntries(2), Eviction(LFU))
+
+		c.Put("a", 1, 0)
+		c.Put("b", 2, 0)
+		c.Get("a")
+		c.Get("a")
+		c.Get("b")
+		c.Put("c", 3, 0)
+
+		if _, _, err := c.Get("b"); err != ErrKeyNotFound {
+			t.Errorf("expected the least frequently used item to be evicted, got err: %v", err)
+		}
+		if _, _, err := c.Get("a"); err != nil {
+			t.Errorf("expected 'a' to be kept, got err: %s", err)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		sizer := func(key string, val interface{}) int64 {
+			return int64(len(val.(string)))
+		}
+		c := NewCache(MaxBytes(10), Sizer(sizer))
+
+		c.Put("a", "12345", 0)
+		c.Put("b", "12345", 0)
+		c.Put("c", "1", 0)
+
+		if _, _, err := c.Get("a"); err != ErrKeyNotFound {
+			t.Errorf("expected 'a' to be evicted, got err: %v", err)
+		}
+		if err := c.Put("d", "12345678901", 0); err != ErrItemTooLarge {
+			t.Errorf("expected ErrItemTooLarge, got: %v", err)
+		}
+	})
+
+	t.Run("Janitor", func(t *testing.T) {
+		c := NewCache(CleanupInterval(10 * time.Millisecond))
+		defer c.(io.Closer).Close()
+
+		c.Put(key, val, 5*time.Millisecond)
+
+		<-time.After(30 * time.Millisecond)
+		mc := c.(*memCache)
+		mc.RLock()
+		n := len(mc.items)
+		mc.RUnlock()
+		if n != 0 {
+			t.Errorf("expected the janitor to purge expired items, %d left", n)
+		}
+	})
+}
+
+func TestCacheStats(t *testing.T) {
+	t.Run("Counters", func(t *testing.T) {
+		c := NewCache(MaxEntries(2))
+		p := c.(StatsProvider)
+
+		c.Put("user:1", val, 0)
+		c.Put("user:2", val, 0)
+		c.Put("order:1", val, 5*time.Millisecond)
+		c.Get("user:2")
+		c.Get("user:1")
+		c.Get("order:9")
+
+		<-time.After(10 * time.Millisecond)
+		c.Get("order:1")
+
+		s := p.Stats()
+		if s.Hits != 1 || s.Misses != 3 || s.Evictions != 1 || s.Expirations != 1 || s.Entries != 1 {
+			t.Errorf("unexpected stats: %+v", s)
+		}
+		if u := s.Prefixes["user"]; u == nil || u.Hits != 1 || u.Misses != 1 || u.Evictions != 1 || u.Entries != 1 {
+			t.Errorf("unexpected user prefix stats: %+v", u)
+		}
+		if o := s.Prefixes["order"]; o == nil || o.Misses != 2 || o.Expirations != 1 || o.Entries != 0 {
+			t.Errorf("unexpected order prefix stats: %+v", o)
+		}
+	})
+
+	t.Run("MaxPrefixes", func(t *testing.T) {
+		c := NewCache(MaxPrefixes(2))
+		p := c.(StatsProvider)
+
+		for i := 0; i < 100; i++ {
+			c.Put(fmt.Sprintf("user-%d:profile", i), val, 0)
+		}
+		c.Delete("user-0:profile")
+		c.Delete("user-99:profile")
+
+		s := p.Stats()
+		if len(s.Prefixes) != 3 {
+			t.Fatalf("expected 2 prefixes and %s, got %d", OtherPrefix, len(s.Prefixes))
+		}
+		if u := s.Prefixes["user-0"]; u == nil || u.Entries != 0 {
+			t.Errorf("unexpected user-0 prefix stats: %+v", u)
+		}
+		if o := s.Prefixes[OtherPrefix]; o == nil || o.Entries != 97 {
+			t.Errorf("unexpected %s prefix stats: %+v", OtherPrefix, o)
+		}
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		c := NewCache()
+		c.Put(key, val, 0)
+		c.Get(key)
+
+		rec := httptest.NewRecorder()
+		StatsHandler(c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cache/stats", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+		var body struct {
+			Hits     uint64  `json:"hits"`
+			HitRatio float64 `json:"hit_ratio"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Hits != 1 || body.HitRatio != 1 {
+			t.Errorf("unexpected stats body: %+v", body)
+		}
+	})
+}
+
+func TestCacheBulkInvalidation(t *testing.T) {
+	t.Run("InvalidateTag", func(t *testing.T) {
+		c := NewCache()
+
+		c.Put("user:1:profile", val, 0, "user:1")
+		c.Put("user:1:orders", val, 0, "user:1", "orders")
+		c.Put("user:2:profile", val, 0, "user:2")
+
+		if err := c.InvalidateTag("user:1"); err != nil {
+			t.Error(err)
+		}
+
+		for _, k := range []string{"user:1:profile", "user:1:orders"} {
+			if _, _, err := c.Get(k); err != ErrKeyNotFound {
+				t.Errorf("expected '%s' to be invalidated, got err: %v", k, err)
+			}
+		}
+		if _, _, err := c.Get("user:2:profile"); err != nil {
+			t.Errorf("expected 'user:2:profile' to be kept, got err: %s", err)
+		}
+		if n := len(c.(*memCache).tags["orders"]); n != 0 {
+			t.Errorf("expected removed keys to leave the tag index, %d left", n)
+		}
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		c := NewCache()
+
+		c.Put("view:home", val, 0)
+		c.Put("view:about", val, 0)
+		c.Put("user:1", val, 0)
+
+		if err := c.DeletePrefix("view:"); err != nil {
+			t.Error(err)
+		}
+
+		if _, _, err := c.Get("view:home"); err != ErrKeyNotFound {
+			t.Errorf("expected 'view:home' to be deleted, got err: %v", err)
+		}
+		if _, _, err := c.Get("user:1"); err != nil {
+			t.Errorf("expected 'user:1' to be kept, got err: %s", err)
+		}
+	})
+}
+
+func TestCacheSnapshot(t *testing.T) {
+	t.Run("SaveLoad", func(t *testing.T) {
+		c := NewCache()
+		c.Put("user:1", val, time.Minute, "users")
+		c.Put("forever", val, 0)
+		c.Put("gone", val, 5*time.Millisecond)
+		<-time.After(10 * time.Millisecond)
+
+		var buf bytes.Buffer
+		if err := c.(Persistent).Save(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := NewCache()
+		if err := restored.(Persistent).Load(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		_, expiration, err := restored.Get("user:1")
+		if err != nil {
+			t.Fatalf("expected a restored value, got err: %s", err)
+		}
+		_, want, _ := c.Get("user:1")
+		if !expiration.Equal(want) {
+			t.Errorf("expected expiration %v, got %v", want, expiration)
+		}
+		if _, _, err := restored.Get("forever"); err != nil {
+			t.Errorf("expected a restored value, got err: %s", err)
+		}
+		if _, _, err := restored.Get("gone"); err != ErrKeyNotFound {
+			t.Errorf("expected expired items to be skipped, got err: %v", err)
+		}
+
+		restored.InvalidateTag("users")
+		if _, _, err := restored.Get("user:1"); err != ErrKeyNotFound {
+			t.Errorf("expected tags to be restored, got err: %v", err)
+		}
+	})
+
+	t.Run("WarmStart", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+		c := NewCache(Snapshot(path, time.Hour))
+		c.Put(key, val, 0)
+		if err := c.(io.Closer).Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		c = NewCache(Snapshot(path, time.Hour))
+		defer c.(io.Closer).Close()
+		if a, _, err := c.Get(key); err != nil {
+			t.Errorf("expected a value from the snapshot, got err: %s", err)
+		} else if a != val {
+			t.Errorf("Expected '%v', got '%v'", val, a)
+		}
+	})
+
+	t.Run("SnapshotError", func(t *testing.T) {
+		type unregistered struct{ Name string }
+
+		errs := make(chan error, 1)
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		c := NewCache(Snapshot(path, 10*time.Millisecond), ErrorHandler(func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}))
+		defer c.(io.Closer).Close()
+		c.Put(key, unregistered{Name: "titan"}, 0)
+
+		select {
+		case err := <-errs:
+			if !strings.Contains(err.Error(), path) {
+				t.Errorf("expected the snapshot path in the error, got: %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the snapshot error to be reported")
+		}
+	})
+
+	t.Run("SnapshotLoadError", func(t *testing.T) {
+		var errs []error
+		handler := ErrorHandler(func(err error) { errs = append(errs, err) })
+
+		// a missing snapshot is a normal cold start
+		path := filepath.Join(t.TempDir(), "cache.snapshot")
+		NewCache(Snapshot(path, 0), handler)
+		if len(errs) != 0 {
+			t.Fatalf("expected no error for a missing snapshot, got: %v", errs)
+		}
+
+		if err := ioutil.WriteFile(path, []byte("corrupt"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := NewCache(Snapshot(path, 0), handler)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), path) {
+			t.Fatalf("expected the load error to be reported, got: %v", errs)
+		}
+		if _, _, err := c.Get(key); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("expected an empty cache, got: %v", err)
+		}
+	})
+}
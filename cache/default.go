@@ -2,8 +2,13 @@ package cache
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 type memCache struct {
@@ -11,7 +16,44 @@ type memCache struct {
 	sync.RWMutex
 	ctx context.Context
 
-	items map[string]Item
+	items   map[string]*entry
+	evictor evictor
+	bytes   int64
+	stats   *counters
+	tags    map[string]map[string]*entry
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newMemCache(options Options) *memCache {
+	c := &memCache{
+		opts:    options,
+		items:   make(map[string]*entry, len(options.Items)),
+		evictor: newEvictor(options.Eviction),
+		stats:   newCounters(options.PrefixSeparator, options.MaxPrefixes),
+		tags:    make(map[string]map[string]*entry),
+	}
+
+	for key, item := range options.Items {
+		c.set(key, item, c.sizeOf(key, item.Value))
+	}
+
+	// an unreadable snapshot only costs a cold start, but it is reported
+	if len(options.SnapshotPath) > 0 {
+		if err := c.loadFile(options.SnapshotPath); err != nil {
+			c.reportError(fmt.Errorf("cache: load snapshot from %s: %w", options.SnapshotPath, err))
+		}
+	}
+
+	if options.CleanupInterval > 0 || (len(options.SnapshotPath) > 0 && options.SnapshotInterval > 0) {
+		c.stop = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.background()
+	}
+
+	return c
 }
 
 func (c *memCache) Context(ctx context.Context) Cache {
@@ -23,18 +65,24 @@ func (c *memCache) Get(key string) (interface{}, time.Time, error) {
 	c.RWMutex.Lock()
 	defer c.RWMutex.Unlock()
 
-	item, found := c.items[key]
+	e, found := c.items[key]
 	if !found {
+		c.stats.miss(key)
 		return nil, time.Time{}, ErrKeyNotFound
 	}
-	if item.Expired() {
+	if e.item.Expired() {
+		c.remove(e)
+		c.stats.expired(key)
+		c.stats.miss(key)
 		return nil, time.Time{}, ErrItemExpired
 	}
 
-	return item.Value, time.Unix(0, item.Expiration), nil
+	c.stats.hit(key)
+	c.evictor.touch(e)
+	return e.item.Value, time.Unix(0, e.item.Expiration), nil
 }
 
-func (c *memCache) Put(key string, val interface{}, d time.Duration) error {
+func (c *memCache) Put(key string, val interface{}, d time.Duration, tags ...string) error {
 	var e int64
 	if d == DefaultExpiration {
 		d = c.opts.Expiration
@@ -43,13 +91,19 @@ func (c *memCache) Put(key string, val interface{}, d time.Duration) error {
 		e = time.Now().Add(d).UnixNano()
 	}
 
-	c.RWMutex.Lock()
-	defer c.RWMutex.Unlock()
-
-	c.items[key] = Item{
+	item := Item{
 		Value:      val,
 		Expiration: e,
 	}
+	size := c.sizeOf(key, val)
+	if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+		return ErrItemTooLarge
+	}
+
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+
+	c.set(key, item, size, tags...)
 
 	return nil
 }
@@ -58,11 +112,239 @@ func (c *memCache) Delete(key string) error {
 	c.RWMutex.Lock()
 	defer c.RWMutex.Unlock()
 
-	_, found := c.items[key]
+	e, found := c.items[key]
 	if !found {
 		return ErrKeyNotFound
 	}
 
-	delete(c.items, key)
+	c.remove(e)
 	return nil
 }
+
+func (c *memCache) InvalidateTag(tag string) error {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+
+	for _, e := range c.tags[tag] {
+		c.remove(e)
+	}
+	return nil
+}
+
+func (c *memCache) DeletePrefix(prefix string) error {
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(e)
+		}
+	}
+	return nil
+}
+
+// Close stops the janitor and the periodic snapshots, a final snapshot is
+// written when the Snapshot option is set.
+func (c *memCache) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+			<-c.done
+		}
+		if len(c.opts.SnapshotPath) > 0 {
+			err = c.saveFile(c.opts.SnapshotPath)
+		}
+	})
+	return err
+}
+
+// DeleteExpired removes all expired items from the cache.
+func (c *memCache) DeleteExpired() {
+	now := time.Now().UnixNano()
+
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+
+	for _, e := range c.items {
+		if e.item.Expiration > 0 && now > e.item.Expiration {
+			c.remove(e)
+			c.stats.expired(e.key)
+		}
+	}
+}
+
+// background runs the janitor and the periodic snapshots
+func (c *memCache) background() {
+	defer close(c.done)
+
+	var cleanup, snapshot <-chan time.Time
+	if c.opts.CleanupInterval > 0 {
+		ticker := time.NewTicker(c.opts.CleanupInterval)
+		defer ticker.Stop()
+		cleanup = ticker.C
+	}
+	if len(c.opts.SnapshotPath) > 0 && c.opts.SnapshotInterval > 0 {
+		ticker := time.NewTicker(c.opts.SnapshotInterval)
+		defer ticker.Stop()
+		snapshot = ticker.C
+	}
+
+	for {
+		select {
+		case <-cleanup:
+			c.DeleteExpired()
+		case <-snapshot:
+			if err := c.saveFile(c.opts.SnapshotPath); err != nil {
+				c.reportError(fmt.Errorf("cache: snapshot to %s: %w", c.opts.SnapshotPath, err))
+			}
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+func (c *memCache) reportError(err error) {
+	if c.opts.ErrorHandler != nil {
+		c.opts.ErrorHandler(err)
+		return
+	}
+	zap.L().Error("cache background error", zap.Error(err))
+}
+
+// set stores the item, making room for it first, must be called with the
+// lock held
+func (c *memCache) set(key string, item Item, size int64, tags ...string) {
+	var freq int
+	if e, found := c.items[key]; found {
+		freq = e.freq
+		c.remove(e)
+	}
+
+	for c.full(1, size) {
+		victim := c.evictor.victim()
+		if victim == nil {
+			break
+		}
+		c.remove(victim)
+		c.stats.evicted(victim.key)
+	}
+
+	e := &entry{key: key, item: item, size: size, tags: tags, freq: freq}
+	c.items[key] = e
+	c.bytes += size
+	c.evictor.add(e)
+	c.stats.added(key)
+
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]*entry)
+			c.tags[tag] = keys
+		}
+		keys[key] = e
+	}
+}
+
+// remove must be called with the lock held
+func (c *memCache) remove(e *entry) {
+	delete(c.items, e.key)
+	c.bytes -= e.size
+	c.evictor.remove(e)
+	c.stats.removed(e.key)
+
+	for _, tag := range e.tags {
+		if keys, ok := c.tags[tag]; ok {
+			delete(keys, e.key)
+			if len(keys) == 0 {
+				delete(c.tags, tag)
+			}
+		}
+	}
+}
+
+// Stats returns a snapshot of the usage counters.
+func (c *memCache) Stats() Stats {
+	c.RWMutex.RLock()
+	defer c.RWMutex.RUnlock()
+
+	return c.stats.snapshot(len(c.items), c.bytes)
+}
+
+// full reports whether adding n items of size bytes exceeds the limits
+func (c *memCache) full(n int, size int64) bool {
+	if c.opts.MaxEntries > 0 && len(c.items)+n > c.opts.MaxEntries {
+		return true
+	}
+	return c.opts.MaxBytes > 0 && c.bytes+size > c.opts.MaxBytes
+}
+
+func (c *memCache) sizeOf(key string, val interface{}) int64 {
+	if c.opts.MaxBytes <= 0 {
+		return 0
+	}
+	if c.opts.Sizer != nil {
+		return c.opts.Sizer(key, val)
+	}
+	return int64(len(key)) + sizeOf(reflect.ValueOf(val), make(map[uintptr]bool))
+}
+
+// sizeOf estimates the memory held by v, pointers are followed once
+func sizeOf(v reflect.Value, seen map[uintptr]bool) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() || seen[v.Pointer()] {
+			return int64(v.Type().Size())
+		}
+		seen[v.Pointer()] = true
+		return int64(v.Type().Size()) + sizeOf(v.Elem(), seen)
+	case reflect.Interface:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+		return int64(v.Type().Size()) + sizeOf(v.Elem(), seen)
+	case reflect.String:
+		return int64(v.Type().Size()) + int64(v.Len())
+	case reflect.Slice:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+		size := int64(v.Type().Size())
+		elem := v.Type().Elem()
+		if elem.Kind() <= reflect.Complex128 {
+			return size + int64(v.Cap())*int64(elem.Size())
+		}
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), seen)
+		}
+		return size
+	case reflect.Array:
+		size := int64(0)
+		for i := 0; i < v.Len(); i++ {
+			size += sizeOf(v.Index(i), seen)
+		}
+		return size
+	case reflect.Map:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+		size := int64(v.Type().Size())
+		iter := v.MapRange()
+		for iter.Next() {
+			size += sizeOf(iter.Key(), seen) + sizeOf(iter.Value(), seen)
+		}
+		return size
+	case reflect.Struct:
+		size := int64(0)
+		for i := 0; i < v.NumField(); i++ {
+			size += sizeOf(v.Field(i), seen)
+		}
+		return size
+	default:
+		return int64(v.Type().Size())
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+)
+
+// EvictionPolicy selects the item removed when the cache is full.
+type EvictionPolicy int
+
+const (
+	// LRU evicts the least recently used item.
+	LRU EvictionPolicy = iota
+	// LFU evicts the least frequently used item, the least recently used
+	// one among items used equally often.
+	LFU
+)
+
+// entry is the bookkeeping of an item stored in memCache.
+type entry struct {
+	key  string
+	item Item
+	size int64
+	tags []string
+
+	// LRU
+	elem *list.Element
+
+	// LFU
+	freq  int
+	tick  uint64
+	index int
+}
+
+// evictor tracks the usage of the entries and picks the next victim.
+// An entry replaced by Put is removed and added again.
+type evictor interface {
+	add(e *entry)
+	touch(e *entry)
+	remove(e *entry)
+	victim() *entry
+}
+
+func newEvictor(p EvictionPolicy) evictor {
+	if p == LFU {
+		return &lfu{}
+	}
+	return &lru{l: list.New()}
+}
+
+type lru struct {
+	l *list.List
+}
+
+func (p *lru) add(e *entry) {
+	e.elem = p.l.PushFront(e)
+}
+
+func (p *lru) touch(e *entry) {
+	p.l.MoveToFront(e.elem)
+}
+
+func (p *lru) remove(e *entry) {
+	p.l.Remove(e.elem)
+	e.elem = nil
+}
+
+func (p *lru) victim() *entry {
+	if back := p.l.Back(); back != nil {
+		return back.Value.(*entry)
+	}
+	return nil
+}
+
+// lfu is a min-heap ordered by use count, then by last use
+type lfu struct {
+	entries []*entry
+	clock   uint64
+}
+
+func (p *lfu) Len() int { return len(p.entries) }
+
+func (p *lfu) Less(i, j int) bool {
+	a, b := p.entries[i], p.entries[j]
+	if a.freq != b.freq {
+		return a.freq < b.freq
+	}
+	return a.tick < b.tick
+}
+
+func (p *lfu) Swap(i, j int) {
+	p.entries[i], p.entries[j] = p.entries[j], p.entries[i]
+	p.entries[i].index = i
+	p.entries[j].index = j
+}
+
+func (p *lfu) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(p.entries)
+	p.entries = append(p.entries, e)
+}
+
+func (p *lfu) Pop() interface{} {
+	n := len(p.entries)
+	e := p.entries[n-1]
+	p.entries[n-1] = nil
+	p.entries = p.entries[:n-1]
+	e.index = -1
+	return e
+}
+
+func (p *lfu) add(e *entry) {
+	p.clock++
+	e.freq++
+	e.tick = p.clock
+	heap.Push(p, e)
+}
+
+func (p *lfu) touch(e *entry) {
+	p.clock++
+	e.freq++
+	e.tick = p.clock
+	heap.Fix(p, e.index)
+}
+
+func (p *lfu) remove(e *entry) {
+	heap.Remove(p, e.index)
+}
+
+func (p *lfu) victim() *entry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	return p.entries[0]
+}
@@ -0,0 +1,245 @@
+// Package layered combines a local cache (L1) of every replica with a shared
+// remote cache (L2). Writes go to both tiers and are broadcast over a
+// broker.Broker topic, so that the other replicas evict their L1 copies.
+// Values and tags are stored in L2 as they are, a tag invalidation also drops
+// every L1 copy that was read from L2.
+package layered
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/cache"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	// DefaultTopic is the topic invalidations are published to
+	DefaultTopic = "titan.cache.invalidate"
+	// HeaderNode identifies the replica that published an invalidation
+	HeaderNode = "X-Cache-Node"
+	// HeaderOp tells whether the body of an invalidation is a key, a tag or
+	// a key prefix
+	HeaderOp = "X-Cache-Op"
+
+	opKey    = "key"
+	opTag    = "tag"
+	opPrefix = "prefix"
+)
+
+// Options represents the options for the layered cache.
+type Options struct {
+	// Topic invalidations are published to
+	Topic string
+	// LocalTTL caps how long a value read from L2 is kept in L1,
+	// 0 keeps it as long as in L2
+	LocalTTL time.Duration
+	// Node identifies this replica, a random id by default
+	Node string
+}
+
+// Option manipulates the Options passed.
+type Option func(o *Options)
+
+// Topic sets the topic invalidations are published to.
+func Topic(name string) Option {
+	return func(o *Options) {
+		o.Topic = name
+	}
+}
+
+// LocalTTL caps how long a value read from L2 is kept in L1.
+func LocalTTL(d time.Duration) Option {
+	return func(o *Options) {
+		o.LocalTTL = d
+	}
+}
+
+// Node sets the id of this replica.
+func Node(id string) Option {
+	return func(o *Options) {
+		o.Node = id
+	}
+}
+
+// remoteTag marks the L1 copies read from L2. Their tags are only known to
+// L2, so every tag invalidation drops them from L1 as well.
+const remoteTag = "\x00layered.remote"
+
+type layeredCache struct {
+	opts   Options
+	local  cache.Cache
+	remote cache.Cache
+	broker broker.Broker
+	sub    broker.Subscriber
+}
+
+// New returns a cache reading from local first and from remote on a miss.
+// b must be connected, New subscribes to the invalidation topic.
+func New(local, remote cache.Cache, b broker.Broker, opts ...Option) (cache.Cache, error) {
+	options := Options{
+		Topic: DefaultTopic,
+		Node:  uuid.NewV4().String(),
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	c := &layeredCache{
+		opts:   options,
+		local:  local,
+		remote: remote,
+		broker: b,
+	}
+
+	sub, err := b.Subscribe(options.Topic, c.onInvalidate)
+	if err != nil {
+		return nil, err
+	}
+	c.sub = sub
+
+	return c, nil
+}
+
+// Context returns a copy of the cache using ctx for both tiers
+func (c *layeredCache) Context(ctx context.Context) cache.Cache {
+	return &layeredCache{
+		opts:   c.opts,
+		local:  c.local.Context(ctx),
+		remote: c.remote.Context(ctx),
+		broker: c.broker,
+		sub:    c.sub,
+	}
+}
+
+func (c *layeredCache) Get(key string) (interface{}, time.Time, error) {
+	if v, expiration, err := c.local.Get(key); err == nil {
+		return v, expiration, nil
+	}
+
+	v, expiration, err := c.remote.Get(key)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if ttl, ok := c.localTTL(expiration); ok {
+		_ = c.local.Put(key, v, ttl, remoteTag)
+	}
+	return v, expiration, nil
+}
+
+func (c *layeredCache) Put(key string, val interface{}, d time.Duration, tags ...string) error {
+	if err := c.remote.Put(key, val, d, tags...); err != nil {
+		return err
+	}
+
+	ttl := d
+	if c.opts.LocalTTL > 0 && (ttl <= 0 || ttl > c.opts.LocalTTL) {
+		ttl = c.opts.LocalTTL
+	}
+	if err := c.local.Put(key, val, ttl, tags...); err != nil {
+		return err
+	}
+
+	return c.invalidate(opKey, key)
+}
+
+func (c *layeredCache) Delete(key string) error {
+	err := c.remote.Delete(key)
+	if err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+		return err
+	}
+
+	lerr := c.local.Delete(key)
+	if perr := c.invalidate(opKey, key); perr != nil {
+		return perr
+	}
+
+	// report a miss only when neither tier held the key
+	if err != nil && lerr != nil {
+		return cache.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (c *layeredCache) InvalidateTag(tag string) error {
+	if err := c.remote.InvalidateTag(tag); err != nil {
+		return err
+	}
+	if err := c.invalidateLocalTag(tag); err != nil {
+		return err
+	}
+	return c.invalidate(opTag, tag)
+}
+
+// invalidateLocalTag drops the L1 entries carrying tag and the copies read
+// from L2, whose tags are unknown to L1
+func (c *layeredCache) invalidateLocalTag(tag string) error {
+	if err := c.local.InvalidateTag(tag); err != nil {
+		return err
+	}
+	return c.local.InvalidateTag(remoteTag)
+}
+
+func (c *layeredCache) DeletePrefix(prefix string) error {
+	if err := c.remote.DeletePrefix(prefix); err != nil {
+		return err
+	}
+	if err := c.local.DeletePrefix(prefix); err != nil {
+		return err
+	}
+	return c.invalidate(opPrefix, prefix)
+}
+
+// Close unsubscribes from the invalidation topic
+func (c *layeredCache) Close() error {
+	return c.sub.Unsubscribe()
+}
+
+// localTTL returns how long a value expiring at expiration is kept in L1
+func (c *layeredCache) localTTL(expiration time.Time) (time.Duration, bool) {
+	var ttl time.Duration
+	if !expiration.IsZero() && expiration.UnixNano() > 0 {
+		ttl = time.Until(expiration)
+		if ttl <= 0 {
+			return 0, false
+		}
+	}
+	if c.opts.LocalTTL > 0 && (ttl == 0 || ttl > c.opts.LocalTTL) {
+		ttl = c.opts.LocalTTL
+	}
+	return ttl, true
+}
+
+func (c *layeredCache) invalidate(op, target string) error {
+	return c.broker.Publish(c.opts.Topic, &broker.Message{
+		Header: map[string]string{
+			HeaderNode: c.opts.Node,
+			HeaderOp:   op,
+		},
+		Body: []byte(target),
+	})
+}
+
+func (c *layeredCache) onInvalidate(e broker.Event) error {
+	msg := e.Message()
+	if msg.Header[HeaderNode] == c.opts.Node {
+		return nil
+	}
+
+	target := string(msg.Body)
+	switch msg.Header[HeaderOp] {
+	case opTag:
+		return c.invalidateLocalTag(target)
+	case opPrefix:
+		return c.local.DeletePrefix(target)
+	default:
+		if err := c.local.Delete(target); err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,115 @@
+package layered
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker/memory"
+	"github.com/dotnetage/go-titan/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayeredCache(t *testing.T) {
+	b := memory.NewBroker()
+	require.NoError(t, b.Connect())
+	defer b.Disconnect()
+
+	remote := cache.NewCache()
+	nodeA, err := New(cache.NewCache(), remote, b, Node("a"))
+	require.NoError(t, err)
+	localB := cache.NewCache()
+	nodeB, err := New(localB, remote, b, Node("b"))
+	require.NoError(t, err)
+
+	t.Run("ReadThroughRemote", func(t *testing.T) {
+		require.NoError(t, nodeA.Put("greeting", "hello", time.Minute))
+
+		v, _, err := nodeB.Get("greeting")
+		require.NoError(t, err)
+		require.Equal(t, "hello", v)
+
+		v, _, err = localB.Get("greeting")
+		require.NoError(t, err)
+		require.Equal(t, "hello", v)
+	})
+
+	t.Run("PutInvalidatesReplicas", func(t *testing.T) {
+		require.NoError(t, nodeA.Put("greeting", "hi", time.Minute))
+
+		_, _, err := localB.Get("greeting")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+
+		v, _, err := nodeB.Get("greeting")
+		require.NoError(t, err)
+		require.Equal(t, "hi", v)
+	})
+
+	t.Run("DeleteInvalidatesReplicas", func(t *testing.T) {
+		_, _, err := nodeB.Get("greeting")
+		require.NoError(t, err)
+
+		require.NoError(t, nodeA.Delete("greeting"))
+
+		_, _, err = nodeB.Get("greeting")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		require.ErrorIs(t, nodeA.Delete("greeting"), cache.ErrKeyNotFound)
+	})
+
+	t.Run("LocalTTL", func(t *testing.T) {
+		local := cache.NewCache()
+		c, err := New(local, remote, b, LocalTTL(10*time.Millisecond))
+		require.NoError(t, err)
+
+		require.NoError(t, remote.Put("short", "lived", time.Minute))
+		_, _, err = c.Get("short")
+		require.NoError(t, err)
+
+		<-time.After(20 * time.Millisecond)
+		_, _, err = local.Get("short")
+		require.Error(t, err)
+	})
+
+	t.Run("InvalidateTag", func(t *testing.T) {
+		require.NoError(t, nodeA.Put("user:1:profile", "p", time.Minute, "user:1"))
+		require.NoError(t, nodeA.Put("user:1:orders", "o", time.Minute, "user:1"))
+		require.NoError(t, nodeA.Put("user:2:profile", "p", time.Minute, "user:2"))
+
+		v, _, err := nodeB.Get("user:1:profile")
+		require.NoError(t, err)
+		require.Equal(t, "p", v)
+
+		require.NoError(t, nodeA.InvalidateTag("user:1"))
+
+		_, _, err = localB.Get("user:1:profile")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		_, _, err = nodeB.Get("user:1:orders")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		_, _, err = nodeB.Get("user:2:profile")
+		require.NoError(t, err)
+	})
+
+	t.Run("RemoteHoldsPlainValues", func(t *testing.T) {
+		require.NoError(t, nodeA.Put("user:3:profile", "p", time.Minute, "user:3"))
+
+		// other clients of L2 read the value itself, tagged or not
+		v, _, err := remote.Get("user:3:profile")
+		require.NoError(t, err)
+		require.Equal(t, "p", v)
+
+		require.NoError(t, remote.InvalidateTag("user:3"))
+		_, _, err = remote.Get("user:3:profile")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		require.NoError(t, nodeA.Put("view:home", "h", time.Minute))
+		_, _, err := nodeB.Get("view:home")
+		require.NoError(t, err)
+
+		require.NoError(t, nodeA.DeletePrefix("view:"))
+
+		_, _, err = nodeB.Get("view:home")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+	})
+}
@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// LoadFunc loads the value of a key missing from the cache.
+type LoadFunc func() (interface{}, error)
+
+// LoaderOptions represents the options for the loader.
+type LoaderOptions struct {
+	// NegativeTTL is how long a negative result is cached, 0 disables it
+	NegativeTTL time.Duration
+	// StaleTTL is how long an expired value is still served while it is
+	// refreshed in the background, 0 disables it
+	StaleTTL time.Duration
+	// IsNegative reports whether an error of the LoadFunc means the value
+	// does not exist, by default errors matching ErrKeyNotFound
+	IsNegative func(err error) bool
+}
+
+// LoaderOption manipulates the LoaderOptions passed.
+type LoaderOption func(o *LoaderOptions)
+
+// NegativeTTL caches negative results of the LoadFunc for d.
+func NegativeTTL(d time.Duration) LoaderOption {
+	return func(o *LoaderOptions) {
+		o.NegativeTTL = d
+	}
+}
+
+// StaleWhileRevalidate serves expired values for up to d while they are
+// refreshed in the background.
+func StaleWhileRevalidate(d time.Duration) LoaderOption {
+	return func(o *LoaderOptions) {
+		o.StaleTTL = d
+	}
+}
+
+// IsNegative sets the function telling negative results apart from failures.
+func IsNegative(fn func(err error) bool) LoaderOption {
+	return func(o *LoaderOptions) {
+		o.IsNegative = fn
+	}
+}
+
+// The loader keeps its bookkeeping under separate keys next to the value,
+// so that the value itself is stored unchanged and readers of the cache
+// never see loader internals. The suffixes keep them within DeletePrefix of
+// the key.
+const (
+	// negativeSuffix marks a key the LoadFunc reported as missing
+	negativeSuffix = "\x00negative"
+	// freshSuffix exists while a value stored with StaleTTL is still fresh
+	freshSuffix = "\x00fresh"
+)
+
+// call is an in-flight load shared by the callers of the same key.
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Loader reads through a Cache: missing keys are loaded once however many
+// callers ask for them at the same time.
+type Loader struct {
+	cache Cache
+	opts  LoaderOptions
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// NewLoader returns a read-through loader over c.
+func NewLoader(c Cache, opts ...LoaderOption) *Loader {
+	options := LoaderOptions{
+		IsNegative: func(err error) bool {
+			return errors.Is(err, ErrKeyNotFound)
+		},
+	}
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return &Loader{
+		cache: c,
+		opts:  options,
+		calls: make(map[string]*call),
+	}
+}
+
+// GetOrLoad returns the cached value of key, or calls fn and caches its
+// result for ttl. A cached negative result is returned as ErrKeyNotFound.
+//
+// With StaleWhileRevalidate the value is kept for ttl plus the stale period,
+// so a direct Get on the cache may return it after ttl until the background
+// refresh replaced it.
+func (l *Loader) GetOrLoad(key string, ttl time.Duration, fn LoadFunc) (interface{}, error) {
+	if v, _, err := l.cache.Get(key); err == nil {
+		if l.staleWhileRevalidate(ttl) {
+			if _, _, err := l.cache.Get(key + freshSuffix); err != nil {
+				l.refresh(key, ttl, fn)
+			}
+		}
+		return v, nil
+	}
+
+	if l.opts.NegativeTTL > 0 {
+		if _, _, err := l.cache.Get(key + negativeSuffix); err == nil {
+			return nil, ErrKeyNotFound
+		}
+	}
+
+	return l.do(key, ttl, fn)
+}
+
+func (l *Loader) staleWhileRevalidate(ttl time.Duration) bool {
+	return l.opts.StaleTTL > 0 && ttl > 0
+}
+
+// do runs fn once per key and shares the result with concurrent callers
+func (l *Loader) do(key string, ttl time.Duration, fn LoadFunc) (interface{}, error) {
+	l.mu.Lock()
+	if c, ok := l.calls[key]; ok {
+		l.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err
+	}
+	c := l.begin(key)
+	l.mu.Unlock()
+
+	l.run(c, key, ttl, fn)
+	return c.val, c.err
+}
+
+// refresh reloads a stale value in the background unless a load of the key
+// is already running
+func (l *Loader) refresh(key string, ttl time.Duration, fn LoadFunc) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, running := l.calls[key]; running {
+		return
+	}
+	c := l.begin(key)
+	go l.run(c, key, ttl, fn)
+}
+
+// begin registers a load of key, the caller must hold the lock
+func (l *Loader) begin(key string) *call {
+	c := &call{}
+	c.wg.Add(1)
+	l.calls[key] = c
+	return c
+}
+
+// run performs the registered load and releases its waiters
+func (l *Loader) run(c *call, key string, ttl time.Duration, fn LoadFunc) {
+	c.val, c.err = l.load(key, ttl, fn)
+	c.wg.Done()
+
+	l.mu.Lock()
+	delete(l.calls, key)
+	l.mu.Unlock()
+}
+
+// load calls fn and stores its result, caching is best effort
+func (l *Loader) load(key string, ttl time.Duration, fn LoadFunc) (interface{}, error) {
+	val, err := fn()
+	if err != nil {
+		if l.opts.NegativeTTL > 0 && l.opts.IsNegative(err) {
+			_ = l.cache.Put(key+negativeSuffix, err.Error(), l.opts.NegativeTTL)
+		}
+		return nil, err
+	}
+
+	if l.staleWhileRevalidate(ttl) {
+		if err := l.cache.Put(key, val, ttl+l.opts.StaleTTL); err == nil {
+			_ = l.cache.Put(key+freshSuffix, true, ttl)
+		}
+	} else {
+		_ = l.cache.Put(key, val, ttl)
+	}
+	if l.opts.NegativeTTL > 0 {
+		_ = l.cache.Delete(key + negativeSuffix)
+	}
+	return val, nil
+}
@@ -0,0 +1,182 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoader(t *testing.T) {
+	t.Run("LoadOnce", func(t *testing.T) {
+		l := NewLoader(NewCache())
+
+		var calls int32
+		release := make(chan struct{})
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return val, nil
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v, err := l.GetOrLoad(key, time.Minute, fn)
+				if err == nil && v != val {
+					err = fmt.Errorf("expected '%v', got '%v'", val, v)
+				}
+				errs <- err
+			}()
+		}
+
+		<-time.After(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected the loader to be called once, got %d", n)
+		}
+		if _, err := l.GetOrLoad(key, time.Minute, fn); err != nil || atomic.LoadInt32(&calls) != 1 {
+			t.Errorf("expected a cached value, got err: %v", err)
+		}
+	})
+
+	t.Run("NegativeTTL", func(t *testing.T) {
+		l := NewLoader(NewCache(), NegativeTTL(20*time.Millisecond))
+
+		var calls int32
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, ErrKeyNotFound
+		}
+
+		for i := 0; i < 3; i++ {
+			if _, err := l.GetOrLoad(key, time.Minute, fn); err != ErrKeyNotFound {
+				t.Errorf("expected ErrKeyNotFound, got: %v", err)
+			}
+		}
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected the negative result to be cached, got %d calls", n)
+		}
+
+		<-time.After(25 * time.Millisecond)
+		l.GetOrLoad(key, time.Minute, fn)
+		if n := atomic.LoadInt32(&calls); n != 2 {
+			t.Errorf("expected the negative result to expire, got %d calls", n)
+		}
+	})
+
+	t.Run("FailureNotCached", func(t *testing.T) {
+		l := NewLoader(NewCache(), NegativeTTL(time.Minute))
+
+		boom := errors.New("boom")
+		if _, err := l.GetOrLoad(key, time.Minute, func() (interface{}, error) {
+			return nil, boom
+		}); err != boom {
+			t.Errorf("expected the loader error, got: %v", err)
+		}
+		if v, err := l.GetOrLoad(key, time.Minute, func() (interface{}, error) {
+			return val, nil
+		}); err != nil || v != val {
+			t.Errorf("expected '%v', got '%v' (err: %v)", val, v, err)
+		}
+	})
+
+	t.Run("StaleWhileRevalidate", func(t *testing.T) {
+		l := NewLoader(NewCache(), StaleWhileRevalidate(time.Minute))
+
+		var version int32
+		refreshed := make(chan struct{}, 1)
+		fn := func() (interface{}, error) {
+			v := atomic.AddInt32(&version, 1)
+			if v > 1 {
+				refreshed <- struct{}{}
+			}
+			return v, nil
+		}
+
+		if v, _ := l.GetOrLoad(key, 10*time.Millisecond, fn); v != int32(1) {
+			t.Fatalf("expected version 1, got '%v'", v)
+		}
+
+		<-time.After(20 * time.Millisecond)
+		if v, _ := l.GetOrLoad(key, 10*time.Millisecond, fn); v != int32(1) {
+			t.Errorf("expected the stale version 1, got '%v'", v)
+		}
+
+		select {
+		case <-refreshed:
+		case <-time.After(time.Second):
+			t.Fatal("expected a background refresh")
+		}
+		<-time.After(5 * time.Millisecond)
+		if v, _ := l.GetOrLoad(key, time.Minute, fn); v != int32(2) {
+			t.Errorf("expected the refreshed version 2, got '%v'", v)
+		}
+	})
+
+	t.Run("CacheHoldsPlainValues", func(t *testing.T) {
+		c := NewCache()
+		l := NewLoader(c, StaleWhileRevalidate(time.Minute), NegativeTTL(time.Minute))
+
+		if _, err := l.GetOrLoad(key, time.Minute, func() (interface{}, error) {
+			return val, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if v, _, err := c.Get(key); err != nil || v != val {
+			t.Errorf("expected '%v' in the cache, got '%v' (err: %v)", val, v, err)
+		}
+
+		l.GetOrLoad("missing", time.Minute, func() (interface{}, error) {
+			return nil, ErrKeyNotFound
+		})
+		if _, _, err := c.Get("missing"); err != ErrKeyNotFound {
+			t.Errorf("expected a negative result to stay out of the key, got: %v", err)
+		}
+	})
+
+	t.Run("RefreshOnce", func(t *testing.T) {
+		l := NewLoader(NewCache(), StaleWhileRevalidate(time.Minute))
+		l.GetOrLoad(key, time.Millisecond, func() (interface{}, error) {
+			return val, nil
+		})
+		<-time.After(5 * time.Millisecond)
+
+		var calls int32
+		release := make(chan struct{})
+		fn := func() (interface{}, error) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			return val, nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				l.GetOrLoad(key, time.Millisecond, fn)
+			}()
+		}
+		wg.Wait()
+		<-time.After(20 * time.Millisecond)
+		close(release)
+
+		if n := atomic.LoadInt32(&calls); n != 1 {
+			t.Errorf("expected one background refresh, got %d", n)
+		}
+	})
+}
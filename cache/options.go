@@ -1,11 +1,42 @@
 package cache
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // Options represents the options for the cache.
 type Options struct {
 	Expiration time.Duration
 	Items      map[string]Item
+	// MaxEntries limits the number of items, 0 means no limit
+	MaxEntries int
+	// MaxBytes limits the estimated size of the items, 0 means no limit
+	MaxBytes int64
+	// Eviction selects the item removed when a limit is exceeded
+	Eviction EvictionPolicy
+	// Sizer estimates the size of an item for MaxBytes
+	Sizer func(key string, val interface{}) int64
+	// CleanupInterval is the interval expired items are purged at,
+	// 0 disables the janitor
+	CleanupInterval time.Duration
+	// PrefixSeparator splits the key prefix counted by Stats
+	PrefixSeparator string
+	// MaxPrefixes limits the number of prefixes broken down by Stats
+	MaxPrefixes int
+	// SnapshotPath is the file the items are restored from on start and
+	// saved to on close
+	SnapshotPath string
+	// SnapshotInterval is the interval snapshots are written at,
+	// 0 only writes on close
+	SnapshotInterval time.Duration
+	// ErrorHandler receives the errors of background work such as loading
+	// and writing snapshots, they are logged with zap.L() when it is not set
+	ErrorHandler func(err error)
+	// Address of the cache server, ignored by the in-memory cache
+	Address string
+	// Context should contain all implementation specific options
+	Context context.Context
 }
 
 // Option manipulates the Options passed.
@@ -25,11 +56,98 @@ func Items(i map[string]Item) Option {
 	}
 }
 
+// MaxEntries limits the number of items held by the cache.
+func MaxEntries(n int) Option {
+	return func(o *Options) {
+		o.MaxEntries = n
+	}
+}
+
+// MaxBytes limits the estimated memory held by the items of the cache.
+func MaxBytes(n int64) Option {
+	return func(o *Options) {
+		o.MaxBytes = n
+	}
+}
+
+// Eviction sets the policy used to remove items when the cache is full.
+func Eviction(p EvictionPolicy) Option {
+	return func(o *Options) {
+		o.Eviction = p
+	}
+}
+
+// Sizer replaces the estimation of the item size used by MaxBytes.
+func Sizer(fn func(key string, val interface{}) int64) Option {
+	return func(o *Options) {
+		o.Sizer = fn
+	}
+}
+
+// CleanupInterval starts a janitor purging expired items on the interval.
+func CleanupInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.CleanupInterval = d
+	}
+}
+
+// PrefixSeparator sets the separator of the key prefixes broken down by
+// Stats, an empty separator counts all keys together.
+func PrefixSeparator(sep string) Option {
+	return func(o *Options) {
+		o.PrefixSeparator = sep
+	}
+}
+
+// MaxPrefixes limits the number of key prefixes broken down by Stats, the
+// counters of further prefixes are added up under OtherPrefix. 0 counts all
+// keys under OtherPrefix.
+func MaxPrefixes(n int) Option {
+	return func(o *Options) {
+		o.MaxPrefixes = n
+	}
+}
+
+// Snapshot restores the items saved in path when the cache is created and
+// saves them every interval and when the cache is closed. A snapshot that
+// cannot be loaded is reported to the ErrorHandler and the cache starts empty.
+func Snapshot(path string, interval time.Duration) Option {
+	return func(o *Options) {
+		o.SnapshotPath = path
+		o.SnapshotInterval = interval
+	}
+}
+
+// ErrorHandler sets the function receiving the errors of background work.
+func ErrorHandler(fn func(err error)) Option {
+	return func(o *Options) {
+		o.ErrorHandler = fn
+	}
+}
+
+// WithAddress sets the address of the cache server.
+func WithAddress(addr string) Option {
+	return func(o *Options) {
+		o.Address = addr
+	}
+}
+
+// WithContext sets the context holding implementation specific options.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
+
 // NewOptions returns a new options struct.
 func NewOptions(opts ...Option) Options {
 	options := Options{
 		Expiration: DefaultExpiration,
 		Items:      make(map[string]Item),
+		Context:    context.Background(),
+
+		PrefixSeparator: DefaultPrefixSeparator,
+		MaxPrefixes:     DefaultMaxPrefixes,
 	}
 
 	for _, o := range opts {
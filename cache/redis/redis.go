@@ -1 +1,368 @@
+// Package redis implements cache.Cache on top of the Redis RESP protocol.
+//
+// Values are gob encoded, so custom types stored in the cache have to be
+// registered with gob.Register by the application.
 package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotnetage/go-titan/cache"
+)
+
+// DefaultAddress is used when cache.WithAddress is not given
+const DefaultAddress = "127.0.0.1:6379"
+
+var (
+	// DefaultMaxIdle is the number of idle connections kept for reuse
+	DefaultMaxIdle = 8
+	// DefaultTagPrefix prefixes the keys of the sets indexing tagged keys
+	DefaultTagPrefix = "titan:tag:"
+	// ScanCount is the number of keys requested per SCAN by DeletePrefix
+	ScanCount = 100
+)
+
+// tagScript adds a key to a tag set and keeps the set alive at least as
+// long as the key: KEYS[1] is the set, ARGV[1] the key and ARGV[2] its TTL
+// in milliseconds, 0 meaning the key does not expire.
+const tagScript = `local existed = redis.call('EXISTS', KEYS[1])
+redis.call('SADD', KEYS[1], ARGV[1])
+local ttl = tonumber(ARGV[2])
+if ttl <= 0 then
+	redis.call('PERSIST', KEYS[1])
+elseif existed == 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+else
+	local current = redis.call('PTTL', KEYS[1])
+	if current >= 0 and current < ttl then
+		redis.call('PEXPIRE', KEYS[1], ttl)
+	end
+end
+return 1`
+
+type maxIdleKey struct{}
+type tagPrefixKey struct{}
+
+// MaxIdle sets the number of idle connections kept for reuse
+func MaxIdle(n int) cache.Option {
+	return func(o *cache.Options) {
+		o.Context = context.WithValue(o.Context, maxIdleKey{}, n)
+	}
+}
+
+// TagPrefix sets the prefix of the keys of the sets indexing tagged keys
+func TagPrefix(prefix string) cache.Option {
+	return func(o *cache.Options) {
+		o.Context = context.WithValue(o.Context, tagPrefixKey{}, prefix)
+	}
+}
+
+// envelope keeps the dynamic type of the cached value
+type envelope struct {
+	Value interface{}
+}
+
+type redisCache struct {
+	opts      cache.Options
+	ctx       context.Context
+	pool      *pool
+	tagPrefix string
+}
+
+// NewCache returns a cache.Cache backed by the Redis server at
+// cache.WithAddress, either "host:port" or "redis://[:password@]host:port[/db]".
+// Connections are dialed lazily on first use.
+func NewCache(opts ...cache.Option) cache.Cache {
+	options := cache.NewOptions(opts...)
+
+	p := &pool{addr: DefaultAddress, maxIdle: DefaultMaxIdle}
+	if n, ok := options.Context.Value(maxIdleKey{}).(int); ok && n >= 0 {
+		p.maxIdle = n
+	}
+	if len(options.Address) > 0 {
+		parseAddress(options.Address, p)
+	}
+
+	tagPrefix := DefaultTagPrefix
+	if prefix, ok := options.Context.Value(tagPrefixKey{}).(string); ok {
+		tagPrefix = prefix
+	}
+
+	return &redisCache{
+		opts:      options,
+		ctx:       context.Background(),
+		pool:      p,
+		tagPrefix: tagPrefix,
+	}
+}
+
+func parseAddress(addr string, p *pool) {
+	if !strings.Contains(addr, "://") {
+		p.addr = addr
+		return
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		p.addr = addr
+		return
+	}
+	if len(u.Host) > 0 {
+		p.addr = u.Host
+		if len(u.Port()) == 0 {
+			p.addr += ":6379"
+		}
+	}
+	if u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			p.password = password
+		} else {
+			p.password = u.User.Username()
+		}
+	}
+	if db, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/")); err == nil {
+		p.db = db
+	}
+}
+
+// Context returns a copy of the cache whose commands honour the deadline
+// and cancellation of ctx
+func (c *redisCache) Context(ctx context.Context) cache.Cache {
+	return &redisCache{
+		opts:      c.opts,
+		ctx:       ctx,
+		pool:      c.pool,
+		tagPrefix: c.tagPrefix,
+	}
+}
+
+func (c *redisCache) Get(key string) (interface{}, time.Time, error) {
+	replies, err := c.do([]string{"GET", key}, []string{"PTTL", key})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	data, _ := replies[0].([]byte)
+	if data == nil {
+		return nil, time.Time{}, cache.ErrKeyNotFound
+	}
+
+	var expiration time.Time
+	if ttl, ok := replies[1].(int64); ok {
+		switch {
+		case ttl == -2:
+			// expired between GET and PTTL
+			return nil, time.Time{}, cache.ErrKeyNotFound
+		case ttl >= 0:
+			expiration = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+
+	var e envelope
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil, time.Time{}, err
+	}
+	return e.Value, expiration, nil
+}
+
+// Put stores the value, the keys of each tag are indexed in a set that
+// lives at least as long as its longest-lived key
+func (c *redisCache) Put(key string, val interface{}, d time.Duration, tags ...string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&envelope{Value: val}); err != nil {
+		return err
+	}
+
+	if d == cache.DefaultExpiration {
+		d = c.opts.Expiration
+	}
+
+	var ms int64
+	args := []string{"SET", key, buf.String()}
+	if d > 0 {
+		if ms = d.Milliseconds(); ms == 0 {
+			ms = 1
+		}
+		args = append(args, "PX", strconv.FormatInt(ms, 10))
+	}
+
+	cmds := [][]string{args}
+	for _, tag := range tags {
+		cmds = append(cmds, []string{"EVAL", tagScript, "1", c.tagPrefix + tag, key, strconv.FormatInt(ms, 10)})
+	}
+
+	_, err := c.do(cmds...)
+	return err
+}
+
+func (c *redisCache) Delete(key string) error {
+	replies, err := c.do([]string{"DEL", key})
+	if err != nil {
+		return err
+	}
+	if n, _ := replies[0].(int64); n == 0 {
+		return cache.ErrKeyNotFound
+	}
+	return nil
+}
+
+func (c *redisCache) InvalidateTag(tag string) error {
+	replies, err := c.do([]string{"SMEMBERS", c.tagPrefix + tag})
+	if err != nil {
+		return err
+	}
+
+	keys, _ := replies[0].([]interface{})
+	args := make([]string, 0, len(keys)+2)
+	args = append(args, "DEL")
+	for _, k := range keys {
+		if b, ok := k.([]byte); ok {
+			args = append(args, string(b))
+		}
+	}
+	args = append(args, c.tagPrefix+tag)
+
+	_, err = c.do(args)
+	return err
+}
+
+// DeletePrefix walks the keyspace with SCAN, which may miss keys written
+// while it runs. The deleted keys are removed from the tag sets as well.
+func (c *redisCache) DeletePrefix(prefix string) error {
+	var deleted []string
+	err := c.scan(globEscape(prefix)+"*", func(keys []string) error {
+		deleted = append(deleted, keys...)
+		_, err := c.do(append([]string{"DEL"}, keys...))
+		return err
+	})
+	if err != nil || len(deleted) == 0 {
+		return err
+	}
+
+	return c.scan(globEscape(c.tagPrefix)+"*", func(sets []string) error {
+		cmds := make([][]string, 0, len(sets))
+		for _, set := range sets {
+			cmds = append(cmds, append([]string{"SREM", set}, deleted...))
+		}
+		_, err := c.do(cmds...)
+		return err
+	})
+}
+
+// scan calls fn with every page of keys matching the pattern
+func (c *redisCache) scan(pattern string, fn func(keys []string) error) error {
+	cursor := "0"
+	for {
+		replies, err := c.do([]string{"SCAN", cursor, "MATCH", pattern, "COUNT", strconv.Itoa(ScanCount)})
+		if err != nil {
+			return err
+		}
+
+		page, _ := replies[0].([]interface{})
+		if len(page) != 2 {
+			return errProtocol
+		}
+		next, _ := page[0].([]byte)
+		items, _ := page[1].([]interface{})
+
+		keys := make([]string, 0, len(items))
+		for _, k := range items {
+			if b, ok := k.([]byte); ok {
+				keys = append(keys, string(b))
+			}
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || len(cursor) == 0 {
+			return nil
+		}
+	}
+}
+
+func globEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Close closes the idle connections
+func (c *redisCache) Close() error {
+	c.pool.close()
+	return nil
+}
+
+// do runs the commands on a pooled connection, error replies are returned
+// as errors
+func (c *redisCache) do(cmds ...[]string) ([]interface{}, error) {
+	ctx := c.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline, _ := ctx.Deadline()
+
+	conn, err := c.pool.get(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// interrupt blocked reads and writes when ctx is cancelled
+	var watcher chan struct{}
+	stop := make(chan struct{})
+	if ctx.Done() != nil {
+		watcher = make(chan struct{})
+		go func() {
+			defer close(watcher)
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(time.Now())
+			case <-stop:
+			}
+		}()
+	}
+
+	replies, err := conn.do(deadline, cmds...)
+	close(stop)
+	if watcher != nil {
+		<-watcher
+	}
+	if err != nil {
+		conn.Close()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		// the socket deadline may fire slightly before the context's timer
+		if ne, ok := err.(net.Error); ok && ne.Timeout() && !deadline.IsZero() {
+			return nil, context.DeadlineExceeded
+		}
+		return nil, err
+	}
+	c.pool.put(conn)
+
+	for _, r := range replies {
+		if e, ok := r.(Error); ok {
+			return nil, e
+		}
+	}
+	return replies, nil
+}
@@ -0,0 +1,429 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+type entry struct {
+	value    string
+	expireAt time.Time
+}
+
+// fakeServer is an in-process stand-in for Redis that understands the
+// commands used by the cache
+type fakeServer struct {
+	sync.Mutex
+	ln       net.Listener
+	password string
+	data     map[string]entry
+	sets     map[string]map[string]bool
+	setTTL   map[string]time.Time
+	stall    chan struct{}
+}
+
+func newFakeServer(t *testing.T, password string) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &fakeServer{
+		ln:       ln,
+		password: password,
+		data:     make(map[string]entry),
+		sets:     make(map[string]map[string]bool),
+		setTTL:   make(map[string]time.Time),
+		stall:    make(chan struct{}),
+	}
+	go s.serve()
+	t.Cleanup(func() {
+		close(s.stall)
+		ln.Close()
+	})
+	return s
+}
+
+func (s *fakeServer) addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(c)
+	}
+}
+
+func (s *fakeServer) handle(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	authed := len(s.password) == 0
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		cmd := strings.ToUpper(args[0])
+		if cmd == "AUTH" {
+			if args[1] != s.password {
+				fmt.Fprint(c, "-WRONGPASS invalid password\r\n")
+				continue
+			}
+			authed = true
+			fmt.Fprint(c, "+OK\r\n")
+			continue
+		}
+		if !authed {
+			fmt.Fprint(c, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		fmt.Fprint(c, s.exec(cmd, args[1:]))
+	}
+}
+
+func (s *fakeServer) exec(cmd string, args []string) string {
+	if len(args) > 0 && args[0] == "stall" {
+		<-s.stall
+		return "$-1\r\n"
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	lookup := func(key string) (entry, bool) {
+		e, ok := s.data[key]
+		if ok && !e.expireAt.IsZero() && time.Now().After(e.expireAt) {
+			delete(s.data, key)
+			return entry{}, false
+		}
+		return e, ok
+	}
+	lookupSet := func(key string) (map[string]bool, bool) {
+		set, ok := s.sets[key]
+		if at, volatile := s.setTTL[key]; ok && volatile && time.Now().After(at) {
+			delete(s.sets, key)
+			delete(s.setTTL, key)
+			return nil, false
+		}
+		return set, ok
+	}
+
+	switch cmd {
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET":
+		e, ok := lookup(args[0])
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(e.value), e.value)
+	case "SET":
+		e := entry{value: args[1]}
+		if len(args) == 4 && strings.ToUpper(args[2]) == "PX" {
+			ms, _ := strconv.Atoi(args[3])
+			e.expireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		s.data[args[0]] = e
+		return "+OK\r\n"
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			if _, ok := lookup(key); ok {
+				delete(s.data, key)
+				n++
+			} else if _, ok := lookupSet(key); ok {
+				delete(s.sets, key)
+				delete(s.setTTL, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SADD":
+		set, ok := s.sets[args[0]]
+		if !ok {
+			set = make(map[string]bool)
+			s.sets[args[0]] = set
+		}
+		n := 0
+		for _, m := range args[1:] {
+			if !set[m] {
+				set[m] = true
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "EVAL":
+		// 仅支持标签集合脚本: 加入成员并保证集合的过期时间不短于成员
+		if args[0] != tagScript {
+			return "-ERR unknown script\r\n"
+		}
+		set, existed := lookupSet(args[2])
+		if !existed {
+			set = make(map[string]bool)
+			s.sets[args[2]] = set
+		}
+		set[args[3]] = true
+		ms, _ := strconv.Atoi(args[4])
+		at, volatile := s.setTTL[args[2]]
+		expireAt := time.Now().Add(time.Duration(ms) * time.Millisecond)
+		switch {
+		case ms <= 0:
+			delete(s.setTTL, args[2])
+		case !existed || (volatile && at.Before(expireAt)):
+			s.setTTL[args[2]] = expireAt
+		}
+		return ":1\r\n"
+	case "SREM":
+		set, _ := lookupSet(args[0])
+		n := 0
+		for _, m := range args[1:] {
+			if set[m] {
+				delete(set, m)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SMEMBERS":
+		set, _ := lookupSet(args[0])
+		var b strings.Builder
+		fmt.Fprintf(&b, "*%d\r\n", len(set))
+		for m := range set {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(m), m)
+		}
+		return b.String()
+	case "SCAN":
+		var keys []string
+		for key := range s.data {
+			if ok, _ := path.Match(args[2], key); ok {
+				if _, live := lookup(key); live {
+					keys = append(keys, key)
+				}
+			}
+		}
+		for key := range s.sets {
+			if ok, _ := path.Match(args[2], key); ok {
+				if _, live := lookupSet(key); live {
+					keys = append(keys, key)
+				}
+			}
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, "*2\r\n$1\r\n0\r\n*%d\r\n", len(keys))
+		for _, key := range keys {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(key), key)
+		}
+		return b.String()
+	case "PTTL":
+		e, ok := lookup(args[0])
+		switch {
+		case !ok:
+			return ":-2\r\n"
+		case e.expireAt.IsZero():
+			return ":-1\r\n"
+		default:
+			return fmt.Sprintf(":%d\r\n", time.Until(e.expireAt).Milliseconds())
+		}
+	default:
+		return fmt.Sprintf("-ERR unknown command '%s'\r\n", cmd)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+type profile struct {
+	Name  string
+	Score int
+}
+
+func init() {
+	gob.Register(&profile{})
+}
+
+func TestRedisCache(t *testing.T) {
+	ctx := context.TODO()
+
+	t.Run("PutGet", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		require.NoError(t, c.Context(ctx).Put("name", "titan", 0))
+		v, expiration, err := c.Context(ctx).Get("name")
+		require.NoError(t, err)
+		require.Equal(t, "titan", v)
+		require.True(t, expiration.IsZero())
+
+		require.NoError(t, c.Context(ctx).Put("profile", &profile{Name: "ray", Score: 7}, time.Minute))
+		v, expiration, err = c.Context(ctx).Get("profile")
+		require.NoError(t, err)
+		require.Equal(t, &profile{Name: "ray", Score: 7}, v)
+		require.WithinDuration(t, time.Now().Add(time.Minute), expiration, time.Second)
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		_, _, err := c.Context(ctx).Get("missing")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		require.ErrorIs(t, c.Context(ctx).Delete("missing"), cache.ErrKeyNotFound)
+
+		require.NoError(t, c.Context(ctx).Put("name", "titan", 0))
+		require.NoError(t, c.Context(ctx).Delete("name"))
+		_, _, err = c.Context(ctx).Get("name")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+	})
+
+	t.Run("Expiration", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()), cache.Expiration(20*time.Millisecond))
+
+		require.NoError(t, c.Context(ctx).Put("name", "titan", 0))
+		_, _, err := c.Context(ctx).Get("name")
+		require.NoError(t, err)
+
+		<-time.After(30 * time.Millisecond)
+		_, _, err = c.Context(ctx).Get("name")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+	})
+
+	t.Run("Auth", func(t *testing.T) {
+		s := newFakeServer(t, "secret")
+
+		c := NewCache(cache.WithAddress("redis://:secret@" + s.addr() + "/1"))
+		require.NoError(t, c.Context(ctx).Put("name", "titan", 0))
+
+		c = NewCache(cache.WithAddress("redis://:wrong@" + s.addr()))
+		require.Error(t, c.Context(ctx).Put("name", "titan", 0))
+	})
+
+	t.Run("ContextTimeout", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+
+		_, _, err := c.Context(timeout).Get("stall")
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		// the connection of the timed out command is not reused
+		require.NoError(t, c.Context(ctx).Put("name", "titan", 0))
+	})
+
+	t.Run("InvalidateTag", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		require.NoError(t, c.Context(ctx).Put("user:1:profile", "p", 0, "user:1"))
+		require.NoError(t, c.Context(ctx).Put("user:1:orders", "o", 0, "user:1", "orders"))
+		require.NoError(t, c.Context(ctx).Put("user:2:profile", "p", 0, "user:2"))
+
+		require.NoError(t, c.Context(ctx).InvalidateTag("user:1"))
+
+		for _, k := range []string{"user:1:profile", "user:1:orders"} {
+			_, _, err := c.Context(ctx).Get(k)
+			require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		}
+		_, _, err := c.Context(ctx).Get("user:2:profile")
+		require.NoError(t, err)
+	})
+
+	t.Run("DeletePrefix", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		require.NoError(t, c.Context(ctx).Put("view:*:1", "a", 0))
+		require.NoError(t, c.Context(ctx).Put("view:*:2", "b", 0))
+		require.NoError(t, c.Context(ctx).Put("view:home", "c", 0))
+
+		require.NoError(t, c.Context(ctx).DeletePrefix("view:*"))
+
+		_, _, err := c.Context(ctx).Get("view:*:1")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+		_, _, err = c.Context(ctx).Get("view:home")
+		require.NoError(t, err)
+	})
+
+	t.Run("TagSetExpires", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		require.NoError(t, c.Context(ctx).Put("a", "a", time.Minute, "group"))
+		require.NoError(t, c.Context(ctx).Put("b", "b", 20*time.Millisecond, "group"))
+		s.Lock()
+		require.WithinDuration(t, time.Now().Add(time.Minute), s.setTTL[DefaultTagPrefix+"group"], time.Second)
+		s.Unlock()
+
+		require.NoError(t, c.Context(ctx).Put("c", "c", 20*time.Millisecond, "short"))
+		<-time.After(30 * time.Millisecond)
+		replies, err := c.(*redisCache).do([]string{"SMEMBERS", DefaultTagPrefix + "short"})
+		require.NoError(t, err)
+		require.Empty(t, replies[0])
+
+		// 不过期的键使标签集合也不过期
+		require.NoError(t, c.Context(ctx).Put("d", "d", 0, "group"))
+		s.Lock()
+		_, volatile := s.setTTL[DefaultTagPrefix+"group"]
+		s.Unlock()
+		require.False(t, volatile)
+	})
+
+	t.Run("DeletePrefixCleansTags", func(t *testing.T) {
+		s := newFakeServer(t, "")
+		c := NewCache(cache.WithAddress(s.addr()))
+
+		require.NoError(t, c.Context(ctx).Put("view:1", "a", time.Minute, "views"))
+		require.NoError(t, c.Context(ctx).Put("other", "b", time.Minute, "views"))
+		require.NoError(t, c.Context(ctx).DeletePrefix("view:"))
+
+		s.Lock()
+		members := s.sets[DefaultTagPrefix+"views"]
+		require.Equal(t, map[string]bool{"other": true}, members)
+		s.Unlock()
+	})
+}
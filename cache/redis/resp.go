@@ -0,0 +1,202 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Error is an error reply sent by the server
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+var errProtocol = errors.New("redis: invalid reply")
+
+// conn is a single connection speaking the RESP protocol
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// do writes all commands before reading the replies, so that a GET and its
+// PTTL take a single round trip
+func (c *conn) do(deadline time.Time, cmds ...[]string) ([]interface{}, error) {
+	if err := c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	for _, args := range cmds {
+		if err := c.write(args); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := c.read()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (c *conn) write(args []string) error {
+	if _, err := fmt.Fprintf(c.w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns the next reply: string for simple strings, Error for errors,
+// int64 for integers, []byte for bulk strings (nil when missing) and
+// []interface{} for arrays
+func (c *conn) read() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errProtocol
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return Error(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, errProtocol
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, errProtocol
+	}
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errProtocol
+	}
+	return line[:len(line)-2], nil
+}
+
+// pool keeps idle connections for reuse
+type pool struct {
+	sync.Mutex
+	addr     string
+	password string
+	db       int
+	maxIdle  int
+	idle     []*conn
+}
+
+func (p *pool) get(deadline time.Time) (*conn, error) {
+	p.Lock()
+	if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.Unlock()
+		return c, nil
+	}
+	p.Unlock()
+
+	d := net.Dialer{Deadline: deadline}
+	nc, err := d.Dial("tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+
+	var cmds [][]string
+	if len(p.password) > 0 {
+		cmds = append(cmds, []string{"AUTH", p.password})
+	}
+	if p.db > 0 {
+		cmds = append(cmds, []string{"SELECT", strconv.Itoa(p.db)})
+	}
+	if len(cmds) > 0 {
+		replies, err := c.do(deadline, cmds...)
+		if err == nil {
+			for _, r := range replies {
+				if e, ok := r.(Error); ok {
+					err = e
+					break
+				}
+			}
+		}
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// put returns c to the pool, broken connections must be closed instead
+func (p *pool) put(c *conn) {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.idle) >= p.maxIdle {
+		c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+}
+
+func (p *pool) close() {
+	p.Lock()
+	defer p.Unlock()
+
+	for _, c := range p.idle {
+		c.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"encoding/gob"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Persistent is implemented by caches that can be saved and restored.
+//
+// Values are gob encoded, so custom types stored in the cache have to be
+// registered with gob.Register by the application.
+type Persistent interface {
+	Save(w io.Writer) error
+	Load(r io.Reader) error
+}
+
+// snapshotItem is the persisted form of an item
+type snapshotItem struct {
+	Key        string
+	Value      interface{}
+	Expiration int64
+	Tags       []string
+}
+
+// Save writes the items that have not expired to w.
+func (c *memCache) Save(w io.Writer) error {
+	now := time.Now().UnixNano()
+
+	c.RWMutex.RLock()
+	items := make([]snapshotItem, 0, len(c.items))
+	for key, e := range c.items {
+		if e.item.Expiration > 0 && now > e.item.Expiration {
+			continue
+		}
+		items = append(items, snapshotItem{
+			Key:        key,
+			Value:      e.item.Value,
+			Expiration: e.item.Expiration,
+			Tags:       e.tags,
+		})
+	}
+	c.RWMutex.RUnlock()
+
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// Load adds the items saved by Save to the cache, keeping their
+// expirations. Items that expired in the meantime are skipped.
+func (c *memCache) Load(r io.Reader) error {
+	var items []snapshotItem
+	if err := gob.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+
+	c.RWMutex.Lock()
+	defer c.RWMutex.Unlock()
+
+	for _, i := range items {
+		if i.Expiration > 0 && now > i.Expiration {
+			continue
+		}
+		size := c.sizeOf(i.Key, i.Value)
+		if c.opts.MaxBytes > 0 && size > c.opts.MaxBytes {
+			continue
+		}
+		c.set(i.Key, Item{Value: i.Value, Expiration: i.Expiration}, size, i.Tags...)
+	}
+	return nil
+}
+
+// saveFile writes a snapshot to path atomically
+func (c *memCache) saveFile(path string) error {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := c.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// loadFile restores the snapshot at path, a missing file is not an error
+func (c *memCache) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	return c.Load(f)
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// DefaultPrefixSeparator splits the prefix used by the stats breakdown from
+// the rest of the key, e.g. "user" for "user:42".
+const DefaultPrefixSeparator = ":"
+
+// DefaultMaxPrefixes is the number of key prefixes broken down by Stats
+// before the remaining ones are counted under OtherPrefix.
+const DefaultMaxPrefixes = 64
+
+// OtherPrefix collects the counters of the prefixes beyond MaxPrefixes.
+const OtherPrefix = "(other)"
+
+// Stats is a snapshot of the usage counters of a cache.
+type Stats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+	// Bytes is the estimated size of the items, only tracked with MaxBytes
+	Bytes int64 `json:"bytes"`
+	// Prefixes breaks the counters down by key prefix, up to MaxPrefixes
+	// prefixes and OtherPrefix
+	Prefixes map[string]*PrefixStats `json:"prefixes,omitempty"`
+}
+
+// PrefixStats holds the counters of the keys sharing a prefix.
+type PrefixStats struct {
+	Hits        uint64 `json:"hits"`
+	Misses      uint64 `json:"misses"`
+	Evictions   uint64 `json:"evictions"`
+	Expirations uint64 `json:"expirations"`
+	Entries     int    `json:"entries"`
+}
+
+// HitRatio returns the share of the lookups that found a value.
+func (s Stats) HitRatio() float64 {
+	if total := s.Hits + s.Misses; total > 0 {
+		return float64(s.Hits) / float64(total)
+	}
+	return 0
+}
+
+// StatsProvider is implemented by caches that count their usage.
+type StatsProvider interface {
+	Stats() Stats
+}
+
+// StatsHandler serves the stats of c as JSON, it responds with
+// 501 Not Implemented when c does not count its usage.
+func StatsHandler(c Cache) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		p, ok := c.(StatsProvider)
+		if !ok {
+			http.Error(w, "cache does not provide stats", http.StatusNotImplemented)
+			return
+		}
+
+		stats := p.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(struct {
+			Stats
+			HitRatio float64 `json:"hit_ratio"`
+		}{stats, stats.HitRatio()})
+	})
+}
+
+// StatsHandlerFunc adapts StatsHandler to the handler signature of
+// gateway.Gateway.Handle.
+func StatsHandlerFunc(c Cache) func(w http.ResponseWriter, req *http.Request, pathParams map[string]string) {
+	h := StatsHandler(c)
+	return func(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+		h.ServeHTTP(w, req)
+	}
+}
+
+// counters keeps the stats of memCache, guarded by the cache lock
+type counters struct {
+	sep      string
+	max      int
+	total    PrefixStats
+	prefixes map[string]*PrefixStats
+}
+
+func newCounters(sep string, max int) *counters {
+	return &counters{sep: sep, max: max, prefixes: make(map[string]*PrefixStats)}
+}
+
+func (c *counters) prefix(key string) *PrefixStats {
+	var name string
+	if len(c.sep) > 0 {
+		if i := strings.Index(key, c.sep); i > 0 {
+			name = key[:i]
+		}
+	}
+
+	p, ok := c.prefixes[name]
+	if !ok {
+		// a prefix is either tracked from its first use or always folded,
+		// so that its entries are added and removed in the same bucket
+		if len(c.prefixes) >= c.max {
+			name = OtherPrefix
+			if p, ok = c.prefixes[name]; ok {
+				return p
+			}
+		}
+		p = &PrefixStats{}
+		c.prefixes[name] = p
+	}
+	return p
+}
+
+func (c *counters) hit(key string) {
+	c.total.Hits++
+	c.prefix(key).Hits++
+}
+
+func (c *counters) miss(key string) {
+	c.total.Misses++
+	c.prefix(key).Misses++
+}
+
+func (c *counters) added(key string) {
+	c.prefix(key).Entries++
+}
+
+func (c *counters) removed(key string) {
+	c.prefix(key).Entries--
+}
+
+func (c *counters) evicted(key string) {
+	c.total.Evictions++
+	c.prefix(key).Evictions++
+}
+
+func (c *counters) expired(key string) {
+	c.total.Expirations++
+	c.prefix(key).Expirations++
+}
+
+func (c *counters) snapshot(entries int, bytes int64) Stats {
+	s := Stats{
+		Hits:        c.total.Hits,
+		Misses:      c.total.Misses,
+		Evictions:   c.total.Evictions,
+		Expirations: c.total.Expirations,
+		Entries:     entries,
+		Bytes:       bytes,
+		Prefixes:    make(map[string]*PrefixStats, len(c.prefixes)),
+	}
+	for name, p := range c.prefixes {
+		cp := *p
+		s.Prefixes[name] = &cp
+	}
+	return s
+}
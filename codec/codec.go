@@ -0,0 +1,51 @@
+// Package codec encodes and decodes message payloads for the broker and
+// other components that move values as bytes.
+package codec
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+// ErrNotProto is returned when a non proto.Message value is given to the proto codec
+var ErrNotProto = errors.New("codec: value is not a proto.Message")
+
+// Marshaler is able to encode and decode values. String returns the
+// content type of the encoded data.
+type Marshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+	String() string
+}
+
+var (
+	mu         sync.RWMutex
+	marshalers = map[string]Marshaler{}
+)
+
+func init() {
+	Register(JSON{})
+	Register(Proto{})
+	Register(Gob{})
+}
+
+// Register makes the marshaler available through ByContentType
+func Register(m Marshaler) {
+	mu.Lock()
+	defer mu.Unlock()
+	marshalers[m.String()] = m
+}
+
+// ByContentType returns the marshaler registered for the content type.
+// Parameters such as "; charset=utf-8" are ignored.
+func ByContentType(contentType string) (Marshaler, bool) {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	m, ok := marshalers[strings.TrimSpace(strings.ToLower(contentType))]
+	return m, ok
+}
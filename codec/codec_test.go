@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type order struct {
+	ID    string
+	Items []string
+	Total float64
+}
+
+func TestCodecs(t *testing.T) {
+	src := order{ID: "42", Items: []string{"book", "pen"}, Total: 12.5}
+
+	for _, m := range []Marshaler{JSON{}, Gob{}} {
+		t.Run(m.String(), func(t *testing.T) {
+			data, err := m.Marshal(&src)
+			require.NoError(t, err)
+
+			var dst order
+			require.NoError(t, m.Unmarshal(data, &dst))
+			require.Equal(t, src, dst)
+		})
+	}
+
+	msg, err := structpb.NewStruct(map[string]interface{}{"id": "42", "total": 12.5})
+	require.NoError(t, err)
+
+	for _, m := range []Marshaler{JSON{}, Proto{}} {
+		t.Run(m.String()+"/proto", func(t *testing.T) {
+			data, err := m.Marshal(msg)
+			require.NoError(t, err)
+
+			dst := &structpb.Struct{}
+			require.NoError(t, m.Unmarshal(data, dst))
+			require.True(t, proto.Equal(msg, dst))
+		})
+	}
+
+	t.Run("ProtoRejectsPlainValues", func(t *testing.T) {
+		_, err := Proto{}.Marshal(&src)
+		require.ErrorIs(t, err, ErrNotProto)
+		require.ErrorIs(t, Proto{}.Unmarshal(nil, &src), ErrNotProto)
+	})
+}
+
+func TestByContentType(t *testing.T) {
+	m, ok := ByContentType("application/json; charset=utf-8")
+	require.True(t, ok)
+	require.Equal(t, JSON{}, m)
+
+	m, ok = ByContentType("application/protobuf")
+	require.True(t, ok)
+	require.Equal(t, Proto{}, m)
+
+	_, ok = ByContentType("text/plain")
+	require.False(t, ok)
+}
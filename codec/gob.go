@@ -0,0 +1,26 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+)
+
+// Gob encodes values with encoding/gob. Concrete types stored in interface
+// fields have to be registered with gob.Register.
+type Gob struct{}
+
+func (Gob) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (Gob) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (Gob) String() string {
+	return "application/x-gob"
+}
@@ -0,0 +1,26 @@
+package codec
+
+import "google.golang.org/protobuf/proto"
+
+// Proto encodes proto messages in the protobuf wire format
+type Proto struct{}
+
+func (Proto) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProto
+	}
+	return proto.Marshal(m)
+}
+
+func (Proto) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProto
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func (Proto) String() string {
+	return "application/protobuf"
+}
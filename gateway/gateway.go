@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"fmt"
 
+	"github.com/dotnetage/go-titan/balancer"
 	"github.com/dotnetage/go-titan/config"
 
 	"io/ioutil"
@@ -123,7 +124,7 @@ func (b *defaultGateway) Start() {
 			err := regFnc(context.Background(),
 				gwmux,
 				endpoint.Addr,
-				buildDialOptions(endpoint, b.logger))
+				buildDialOptions(endpoint, b.options.Balancer, b.logger))
 
 			if err != nil {
 				b.logger.Sugar().Fatalf("连接服务失败 %v", err)
@@ -212,7 +213,7 @@ func defaultMarshalerOption() runtime.ServeMuxOption {
 	)
 }
 
-func buildDialOptions(endpoint *config.EndPoint, logger *zap.Logger) []grpc.DialOption {
+func buildDialOptions(endpoint *config.EndPoint, balancerName string, logger *zap.Logger) []grpc.DialOption {
 	grpc_zap.ReplaceGrpcLoggerV2(logger)
 	opts := []grpc.DialOption{
 		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(
@@ -229,6 +230,10 @@ func buildDialOptions(endpoint *config.EndPoint, logger *zap.Logger) []grpc.Dial
 		// grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"round_robin":{}}]}`), // 已经支持负载均衡
 	}
 
+	if len(balancerName) > 0 {
+		opts = append(opts, balancer.DialOption(balancerName))
+	}
+
 	if endpoint.TLS {
 		// creds, _ := credentials.NewClientTLSFromFile(endpoint.CertFile, "")
 		creds, err := loadTLSCredentials(endpoint.CAFile,
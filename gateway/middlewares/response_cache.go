@@ -0,0 +1,385 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/cache"
+	"github.com/dotnetage/go-titan/gateway"
+)
+
+const (
+	// HeaderXCache 标识响应是否来自缓存(HIT/MISS)
+	HeaderXCache = "X-Cache"
+	// DefaultResponseCachePrefix 响应缓存键的默认前缀，
+	// 可通过 cache.Cache.DeletePrefix(DefaultResponseCachePrefix+path) 清除指定路径的缓存
+	DefaultResponseCachePrefix = "http:"
+)
+
+// cachedResponse 已缓存的响应
+type cachedResponse struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	ETag     string
+	StoredAt time.Time
+	// Shared 响应标记为 public 或带有 s-maxage，可提供给已认证的请求
+	Shared bool
+}
+
+func init() {
+	gob.Register(&cachedResponse{})
+}
+
+type responseCacheOptions struct {
+	ttl      time.Duration
+	prefix   string
+	headers  []string
+	byUser   bool
+	tags     func(req *http.Request) []string
+	maxBytes int
+}
+
+// ResponseCacheOption 响应缓存选项
+type ResponseCacheOption func(*responseCacheOptions)
+
+// CacheTTL 设置响应未指定 max-age 时的缓存时长，默认为1分钟
+func CacheTTL(d time.Duration) ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		o.ttl = d
+	}
+}
+
+// CacheKeyPrefix 设置缓存键的前缀
+func CacheKeyPrefix(prefix string) ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		o.prefix = prefix
+	}
+}
+
+// VaryHeaders 将指定的请求头加入缓存键，不同的取值分别缓存
+func VaryHeaders(headers ...string) ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		for _, h := range headers {
+			o.headers = append(o.headers, http.CanonicalHeaderKey(h))
+		}
+	}
+}
+
+// VaryByUser 按当前用户分别缓存，同时允许缓存标记为 private 的响应
+func VaryByUser() ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		o.byUser = true
+	}
+}
+
+// CacheTags 为缓存的响应添加标签，以便通过 cache.Cache.InvalidateTag 批量清除
+func CacheTags(fn func(req *http.Request) []string) ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		o.tags = fn
+	}
+}
+
+// CacheMaxBodySize 超过指定字节数的响应不进行缓存，默认为1MB
+func CacheMaxBodySize(n int) ResponseCacheOption {
+	return func(o *responseCacheOptions) {
+		o.maxBytes = n
+	}
+}
+
+// ResponseCache 缓存GET请求的响应，缓存键由路径、查询参数及选定的请求头组成。
+// 支持请求与响应的 Cache-Control，并通过 ETag 与 If-None-Match 返回 304。
+//
+// 未设置 VaryByUser 时，已认证请求的响应只有标记为 public 或带有 s-maxage 时才会缓存，
+// 以免将一个用户的响应提供给其他用户。无法缓存的响应不经缓冲直接写入客户端
+func ResponseCache(c cache.Cache, opts ...ResponseCacheOption) gateway.Middleware {
+	options := &responseCacheOptions{
+		ttl:      time.Minute,
+		prefix:   DefaultResponseCachePrefix,
+		maxBytes: 1 << 20,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != http.MethodGet {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+			if _, ok := reqCC["no-store"]; ok {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			key, ok := responseCacheKey(req, options)
+			if !ok {
+				next.ServeHTTP(w, req)
+				return
+			}
+
+			authenticated := isAuthenticated(req)
+
+			// no-cache 要求跳过缓存读取，但仍可写入新的响应
+			if _, noCache := reqCC["no-cache"]; !noCache {
+				if v, _, err := c.Context(req.Context()).Get(key); err == nil {
+					if cached, ok := v.(*cachedResponse); ok && (cached.Shared || options.byUser || !authenticated) {
+						w.Header().Set(HeaderXCache, "HIT")
+						w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.StoredAt).Seconds())))
+						writeCachedResponse(w, req, cached)
+						return
+					}
+				}
+			}
+
+			cw := &cacheWriter{
+				w:             w,
+				header:        make(http.Header),
+				options:       options,
+				authenticated: authenticated,
+			}
+			next.ServeHTTP(cw, req)
+			if !cw.wroteHeader {
+				cw.WriteHeader(http.StatusOK)
+			}
+			if cw.passthrough {
+				return
+			}
+
+			resp := &cachedResponse{
+				Status:   cw.status,
+				Header:   cw.header,
+				Body:     cw.body.Bytes(),
+				ETag:     cw.header.Get("ETag"),
+				StoredAt: time.Now(),
+				Shared:   cw.shared,
+			}
+			if len(resp.ETag) == 0 {
+				sum := sha1.Sum(resp.Body)
+				resp.ETag = `W/"` + hex.EncodeToString(sum[:]) + `"`
+				resp.Header.Set("ETag", resp.ETag)
+			}
+
+			var tags []string
+			if options.tags != nil {
+				tags = options.tags(req)
+			}
+			_ = c.Context(req.Context()).Put(key, resp, cw.ttl, tags...)
+
+			w.Header().Set(HeaderXCache, "MISS")
+			writeCachedResponse(w, req, resp)
+		})
+	}
+}
+
+// isAuthenticated 请求是否携带了身份凭据或已认证的用户
+func isAuthenticated(req *http.Request) bool {
+	if len(req.Header.Get("Authorization")) > 0 {
+		return true
+	}
+	user, ok := auth.AuthUser(req.Context())
+	return ok && user != nil
+}
+
+// responseCacheKey 生成缓存键，需按用户缓存但用户未认证时不进行缓存
+func responseCacheKey(req *http.Request, options *responseCacheOptions) (string, bool) {
+	var vary []string
+	for _, h := range options.headers {
+		vary = append(vary, h+"="+strings.Join(req.Header.Values(h), ","))
+	}
+	if options.byUser {
+		user, ok := auth.AuthUser(req.Context())
+		if !ok || user == nil {
+			return "", false
+		}
+		vary = append(vary, "user="+user.ID)
+	}
+
+	key := options.prefix + req.URL.Path
+	if query := req.URL.Query(); len(query) > 0 {
+		key += "?" + query.Encode()
+	}
+	if len(vary) > 0 {
+		sum := sha1.Sum([]byte(strings.Join(vary, "\n")))
+		key += "#" + hex.EncodeToString(sum[:])
+	}
+	return key, true
+}
+
+// responseTTL 根据响应的 Cache-Control 计算缓存时长，shared 表示响应可提供给其他用户
+func responseTTL(header http.Header, options *responseCacheOptions, authenticated bool) (ttl time.Duration, shared bool, ok bool) {
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if _, ok := cc["no-store"]; ok {
+		return 0, false, false
+	}
+	if _, ok := cc["private"]; ok && !options.byUser {
+		return 0, false, false
+	}
+	if len(header.Values("Set-Cookie")) > 0 {
+		return 0, false, false
+	}
+
+	_, public := cc["public"]
+	_, sMaxAge := cc["s-maxage"]
+	shared = public || sMaxAge
+
+	// 共享缓存不得存储已认证请求的响应，除非响应明确允许 (RFC 7234 3.2)
+	if authenticated && !options.byUser && !shared {
+		return 0, false, false
+	}
+
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0, false, false
+			}
+			return time.Duration(seconds) * time.Second, shared, true
+		}
+	}
+	return options.ttl, shared, options.ttl > 0
+}
+
+func writeCachedResponse(w http.ResponseWriter, req *http.Request, resp *cachedResponse) {
+	header := w.Header()
+	for k, v := range resp.Header {
+		header[k] = v
+	}
+
+	if len(resp.ETag) > 0 && etagMatch(req.Header.Get("If-None-Match"), resp.ETag) {
+		header.Del("Content-Length")
+		header.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
+
+// etagMatch 按弱比较判断 If-None-Match 是否包含 etag
+func etagMatch(ifNoneMatch, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	weak := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == weak {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		name, arg := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, arg = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = arg
+	}
+	return directives
+}
+
+// cacheWriter 缓冲可缓存的响应；响应头表明无法缓存、响应体超过上限或处理器
+// 要求 Flush 时，改为直接写入客户端
+type cacheWriter struct {
+	w             http.ResponseWriter
+	header        http.Header
+	status        int
+	body          bytes.Buffer
+	wroteHeader   bool
+	passthrough   bool
+	options       *responseCacheOptions
+	authenticated bool
+	ttl           time.Duration
+	shared        bool
+}
+
+func (cw *cacheWriter) Header() http.Header {
+	if cw.passthrough {
+		return cw.w.Header()
+	}
+	return cw.header
+}
+
+func (cw *cacheWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	ttl, shared, ok := responseTTL(cw.header, cw.options, cw.authenticated)
+	if status != http.StatusOK || !ok {
+		cw.bypass()
+		return
+	}
+	if n, err := strconv.Atoi(cw.header.Get("Content-Length")); err == nil && n > cw.options.maxBytes {
+		cw.bypass()
+		return
+	}
+	cw.ttl, cw.shared = ttl, shared
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.passthrough && cw.body.Len()+len(b) > cw.options.maxBytes {
+		cw.bypass()
+	}
+	if cw.passthrough {
+		return cw.w.Write(b)
+	}
+	return cw.body.Write(b)
+}
+
+// Flush 流式响应无法缓存，写出已缓冲的内容后直接写入客户端
+func (cw *cacheWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.bypass()
+	if f, ok := cw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// bypass 放弃缓存，将响应头与已缓冲的响应体写入客户端
+func (cw *cacheWriter) bypass() {
+	if cw.passthrough {
+		return
+	}
+	cw.passthrough = true
+
+	header := cw.w.Header()
+	for k, v := range cw.header {
+		header[k] = v
+	}
+	header.Set(HeaderXCache, "MISS")
+	cw.w.WriteHeader(cw.status)
+	if cw.body.Len() > 0 {
+		cw.w.Write(cw.body.Bytes())
+		cw.body.Reset()
+	}
+}
@@ -0,0 +1,178 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	newHandler := func(opts ...ResponseCacheOption) (http.Handler, *int) {
+		calls := 0
+		next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			if cc := req.URL.Query().Get("cc"); cc != "" {
+				w.Header().Set("Cache-Control", cc)
+			}
+			user := "anonymous"
+			if u, ok := auth.AuthUser(req.Context()); ok {
+				user = u.Name
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"user":%q,"calls":%d}`, user, calls)
+		})
+		return ResponseCache(cache.NewCache(), opts...)(next), &calls
+	}
+
+	get := func(h http.Handler, target string, header http.Header, user *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if user != nil {
+			req = req.WithContext(auth.ContextWithUser(req.Context(), user))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("HitAndNotModified", func(t *testing.T) {
+		h, calls := newHandler()
+
+		first := get(h, "/api/users?b=2&a=1", nil, nil)
+		require.Equal(t, http.StatusOK, first.Code)
+		require.Equal(t, "MISS", first.Header().Get(HeaderXCache))
+		etag := first.Header().Get("ETag")
+		require.NotEmpty(t, etag)
+
+		second := get(h, "/api/users?a=1&b=2", nil, nil)
+		require.Equal(t, "HIT", second.Header().Get(HeaderXCache))
+		require.Equal(t, first.Body.String(), second.Body.String())
+		require.Equal(t, "application/json", second.Header().Get("Content-Type"))
+
+		notModified := get(h, "/api/users?a=1&b=2", http.Header{"If-None-Match": {etag}}, nil)
+		require.Equal(t, http.StatusNotModified, notModified.Code)
+		require.Empty(t, notModified.Body.String())
+		require.Equal(t, 1, *calls)
+	})
+
+	t.Run("CacheControl", func(t *testing.T) {
+		h, calls := newHandler()
+
+		get(h, "/api/users?cc=no-store", nil, nil)
+		get(h, "/api/users?cc=no-store", nil, nil)
+		require.Equal(t, 2, *calls)
+
+		get(h, "/api/orders", nil, nil)
+		rec := get(h, "/api/orders", http.Header{"Cache-Control": {"no-cache"}}, nil)
+		require.Equal(t, "MISS", rec.Header().Get(HeaderXCache))
+		require.Equal(t, 4, *calls)
+
+		get(h, "/api/profile?cc=private", nil, nil)
+		get(h, "/api/profile?cc=private", nil, nil)
+		require.Equal(t, 6, *calls)
+	})
+
+	t.Run("VaryByUser", func(t *testing.T) {
+		h, calls := newHandler(VaryByUser())
+		alice := &auth.Principal{ID: "1", Name: "alice"}
+		bob := &auth.Principal{ID: "2", Name: "bob"}
+
+		get(h, "/api/me?cc=private", nil, alice)
+		rec := get(h, "/api/me?cc=private", nil, bob)
+		require.Contains(t, rec.Body.String(), "bob")
+
+		rec = get(h, "/api/me?cc=private", nil, alice)
+		require.Equal(t, "HIT", rec.Header().Get(HeaderXCache))
+		require.Contains(t, rec.Body.String(), "alice")
+
+		get(h, "/api/me", nil, nil)
+		get(h, "/api/me", nil, nil)
+		require.Equal(t, 4, *calls)
+	})
+
+	t.Run("AuthenticatedNotShared", func(t *testing.T) {
+		h, calls := newHandler()
+		alice := &auth.Principal{ID: "1", Name: "alice"}
+		bob := &auth.Principal{ID: "2", Name: "bob"}
+
+		get(h, "/api/me", nil, alice)
+		rec := get(h, "/api/me", nil, bob)
+		require.Equal(t, "MISS", rec.Header().Get(HeaderXCache))
+		require.Contains(t, rec.Body.String(), "bob")
+
+		rec = get(h, "/api/me", http.Header{"Authorization": {"Bearer token"}}, nil)
+		require.Equal(t, "MISS", rec.Header().Get(HeaderXCache))
+		require.Equal(t, 3, *calls)
+
+		// 响应明确允许共享时可提供给其他用户
+		get(h, "/api/catalog?cc=public", nil, alice)
+		rec = get(h, "/api/catalog?cc=public", nil, bob)
+		require.Equal(t, "HIT", rec.Header().Get(HeaderXCache))
+		require.Equal(t, 4, *calls)
+
+		// 匿名请求缓存的响应不提供给已认证的用户
+		get(h, "/api/home", nil, nil)
+		rec = get(h, "/api/home", nil, bob)
+		require.Contains(t, rec.Body.String(), "bob")
+		require.Equal(t, 6, *calls)
+	})
+
+	t.Run("VaryHeaders", func(t *testing.T) {
+		h, calls := newHandler(VaryHeaders("Accept-Language"))
+
+		get(h, "/api/i18n", http.Header{"Accept-Language": {"zh-CN"}}, nil)
+		get(h, "/api/i18n", http.Header{"Accept-Language": {"en"}}, nil)
+		get(h, "/api/i18n", http.Header{"Accept-Language": {"zh-CN"}}, nil)
+		require.Equal(t, 2, *calls)
+	})
+}
+
+func TestResponseCachePassthrough(t *testing.T) {
+	t.Run("Flush", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h := ResponseCache(cache.NewCache())(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "data: 1\n\n")
+			w.(http.Flusher).Flush()
+			require.True(t, rec.Flushed)
+			require.Equal(t, "data: 1\n\n", rec.Body.String())
+			fmt.Fprint(w, "data: 2\n\n")
+		}))
+
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+		require.Equal(t, "MISS", rec.Header().Get(HeaderXCache))
+		require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+		require.Equal(t, "data: 1\n\ndata: 2\n\n", rec.Body.String())
+	})
+
+	t.Run("Uncacheable", func(t *testing.T) {
+		calls := 0
+		var rec *httptest.ResponseRecorder
+		h := ResponseCache(cache.NewCache(), CacheMaxBodySize(8))(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			if req.URL.Path == "/cookie" {
+				w.Header().Set("Set-Cookie", "session=1")
+			}
+			fmt.Fprint(w, "0123456789")
+			// 无法缓存的响应在处理器返回前即已写入客户端
+			require.Equal(t, "0123456789", rec.Body.String())
+			fmt.Fprint(w, "abc")
+		}))
+
+		for _, path := range []string{"/large", "/large", "/cookie", "/cookie"} {
+			rec = httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			require.Equal(t, "MISS", rec.Header().Get(HeaderXCache))
+			require.Equal(t, "0123456789abc", rec.Body.String())
+		}
+		require.Equal(t, 4, calls)
+	})
+}
@@ -21,6 +21,7 @@ type Options struct {
 	Registry    registry.Registry // 注册中心
 	Logger      *zap.Logger       // 日志
 	CORS        *config.CORSConfig
+	Balancer    string // 连接gRPC服务时使用的负载均衡策略
 }
 
 func newOptions(opts ...Option) *Options {
@@ -89,6 +90,13 @@ func AllowHeaders(headers ...string) Option {
 	}
 }
 
+// Balancer 设置连接gRPC服务时使用的负载均衡策略，如 balancer.WeightedRoundRobin
+func Balancer(name string) Option {
+	return func(o *Options) {
+		o.Balancer = name
+	}
+}
+
 func Logger(logger *zap.Logger) Option {
 	return func(o *Options) {
 		o.Logger = logger
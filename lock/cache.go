@@ -0,0 +1,166 @@
+package lock
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/cache"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// record is the value stored for a held lock
+type record struct {
+	Owner string
+	Token uint64
+}
+
+func init() {
+	gob.Register(&record{})
+}
+
+type cacheLocker struct {
+	sync.Mutex
+	opts  Options
+	cache cache.Cache
+	// fences keeps the last token of every lock, so that an evicted
+	// counter in the cache does not restart the tokens
+	fences map[string]uint64
+}
+
+// NewCacheLocker returns a Locker keeping its locks in c.
+//
+// The cache interface has no atomic compare-and-set, so the check and the
+// write of a lock are only serialised within this Locker. Use it for tests
+// and single-instance deployments, and a backend such as lock/etcd across
+// replicas.
+//
+// The fencing tokens are counted by the Locker and mirrored to the cache for
+// the next process. A cache shared with other data should not evict, a
+// bounded cache may drop a held lock or the mirrored counter.
+func NewCacheLocker(c cache.Cache, opts ...Option) Locker {
+	return &cacheLocker{
+		opts:   NewOptions(opts...),
+		cache:  c,
+		fences: make(map[string]uint64),
+	}
+}
+
+func (l *cacheLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (Lease, error) {
+	for {
+		lease, err := l.TryAcquire(ctx, name, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return lease, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(l.opts.RetryInterval):
+		}
+	}
+}
+
+func (l *cacheLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lease, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	key := l.opts.Prefix + name
+	c := l.cache.Context(ctx)
+	// only a missing or expired entry means the lock is free, any other
+	// error leaves the state of the lock unknown
+	if _, _, err := c.Get(key); err == nil {
+		return nil, ErrLocked
+	} else if !missing(err) {
+		return nil, err
+	}
+
+	// the fencing counter outlives the locks
+	last := l.fences[key]
+	v, _, err := c.Get(key + ".fence")
+	if err == nil {
+		if stored, ok := v.(uint64); ok && stored > last {
+			last = stored
+		}
+	} else if !missing(err) {
+		return nil, err
+	}
+	token := last + 1
+	if err := c.Put(key+".fence", token, -1); err != nil {
+		return nil, err
+	}
+	l.fences[key] = token
+
+	rec := &record{Owner: uuid.NewV4().String(), Token: token}
+	if err := c.Put(key, rec, ttl); err != nil {
+		return nil, err
+	}
+
+	return &cacheLease{locker: l, name: name, key: key, ttl: ttl, rec: *rec}, nil
+}
+
+type cacheLease struct {
+	locker *cacheLocker
+	name   string
+	key    string
+	ttl    time.Duration
+	rec    record
+}
+
+func (l *cacheLease) Name() string {
+	return l.name
+}
+
+func (l *cacheLease) Token() uint64 {
+	return l.rec.Token
+}
+
+func (l *cacheLease) Refresh(ctx context.Context) error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	c := l.locker.cache.Context(ctx)
+	if err := l.owned(c); err != nil {
+		return err
+	}
+	rec := l.rec
+	return c.Put(l.key, &rec, l.ttl)
+}
+
+func (l *cacheLease) Release(ctx context.Context) error {
+	l.locker.Lock()
+	defer l.locker.Unlock()
+
+	c := l.locker.cache.Context(ctx)
+	if err := l.owned(c); err != nil {
+		return err
+	}
+	return c.Delete(l.key)
+}
+
+// owned must be called with the locker held
+func (l *cacheLease) owned(c cache.Cache) error {
+	v, _, err := c.Get(l.key)
+	if err != nil {
+		if missing(err) {
+			return ErrLeaseLost
+		}
+		return err
+	}
+	if rec, ok := v.(*record); !ok || rec.Owner != l.rec.Owner {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// missing reports whether err means the entry does not exist
+func missing(err error) bool {
+	return errors.Is(err, cache.ErrKeyNotFound) || errors.Is(err, cache.ErrItemExpired)
+}
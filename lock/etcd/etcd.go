@@ -0,0 +1,153 @@
+// Package etcd implements lock.Locker on etcd.
+//
+// A lock is a key attached to an etcd lease, created only when it does not
+// exist. The revision of the creating write is the fencing token, it grows
+// with every acquisition across the whole cluster.
+package etcd
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dotnetage/go-titan/lock"
+
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+type etcdLocker struct {
+	opts lock.Options
+	cli  *clientv3.Client
+}
+
+// NewLocker returns a Locker keeping its locks in etcd, cli may be shared
+// with the registry
+func NewLocker(cli *clientv3.Client, opts ...lock.Option) lock.Locker {
+	return &etcdLocker{
+		opts: lock.NewOptions(opts...),
+		cli:  cli,
+	}
+}
+
+func (l *etcdLocker) Acquire(ctx context.Context, name string, ttl time.Duration) (lock.Lease, error) {
+	key := l.opts.Prefix + name
+	for {
+		lease, rev, err := l.try(ctx, name, ttl)
+		if err != lock.ErrLocked {
+			return lease, err
+		}
+
+		// wait for the holder to release the lock or for its lease to expire
+		wctx, cancel := context.WithCancel(ctx)
+		events := l.cli.Watch(wctx, key, clientv3.WithRev(rev+1), clientv3.WithFilterPut())
+		for resp := range events {
+			if resp.Err() != nil || len(resp.Events) > 0 {
+				break
+			}
+		}
+		cancel()
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (l *etcdLocker) TryAcquire(ctx context.Context, name string, ttl time.Duration) (lock.Lease, error) {
+	lease, _, err := l.try(ctx, name, ttl)
+	return lease, err
+}
+
+// try creates the lock key, the revision of the response is returned when
+// the lock is held by another owner
+func (l *etcdLocker) try(ctx context.Context, name string, ttl time.Duration) (lock.Lease, int64, error) {
+	seconds := int64(ttl / time.Second)
+	if ttl%time.Second > 0 {
+		seconds++
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	grant, err := l.cli.Grant(ctx, seconds)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	key := l.opts.Prefix + name
+	resp, err := l.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(key), "=", 0)).
+		Then(clientv3.OpPut(key, "", clientv3.WithLease(grant.ID))).
+		Commit()
+	if err != nil {
+		l.revoke(grant.ID)
+		return nil, 0, err
+	}
+
+	if !resp.Succeeded {
+		l.revoke(grant.ID)
+		return nil, resp.Header.Revision, lock.ErrLocked
+	}
+
+	return &etcdLease{
+		cli:   l.cli,
+		name:  name,
+		key:   key,
+		id:    grant.ID,
+		token: uint64(resp.Header.Revision),
+	}, 0, nil
+}
+
+func (l *etcdLocker) revoke(id clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = l.cli.Revoke(ctx, id)
+}
+
+type etcdLease struct {
+	cli   *clientv3.Client
+	name  string
+	key   string
+	id    clientv3.LeaseID
+	token uint64
+}
+
+func (l *etcdLease) Name() string {
+	return l.name
+}
+
+func (l *etcdLease) Token() uint64 {
+	return l.token
+}
+
+func (l *etcdLease) Refresh(ctx context.Context) error {
+	resp, err := l.cli.KeepAliveOnce(ctx, l.id)
+	if err != nil {
+		if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+			return lock.ErrLeaseLost
+		}
+		return err
+	}
+	if resp.TTL <= 0 {
+		return lock.ErrLeaseLost
+	}
+	return nil
+}
+
+func (l *etcdLease) Release(ctx context.Context) error {
+	resp, err := l.cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(l.key), "=", int64(l.token))).
+		Then(clientv3.OpDelete(l.key)).
+		Commit()
+	if err != nil {
+		return err
+	}
+
+	_, _ = l.cli.Revoke(ctx, l.id)
+
+	if !resp.Succeeded {
+		return lock.ErrLeaseLost
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package etcd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/lock"
+
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// newTestClient connects to the etcd cluster in ETCD_ENDPOINTS, by default
+// 127.0.0.1:2379, and skips the test when it is not reachable
+func newTestClient(t *testing.T) *clientv3.Client {
+	endpoints := []string{"127.0.0.1:2379"}
+	if v := os.Getenv("ETCD_ENDPOINTS"); len(v) > 0 {
+		endpoints = strings.Split(v, ",")
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: time.Second})
+	if err != nil {
+		t.Skipf("etcd is not available: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cli.Status(ctx, endpoints[0]); err != nil {
+		cli.Close()
+		t.Skipf("etcd is not available: %v", err)
+	}
+
+	t.Cleanup(func() { cli.Close() })
+	return cli
+}
+
+func TestEtcdLocker(t *testing.T) {
+	cli := newTestClient(t)
+	ctx := context.Background()
+	prefix := "titan/test/locks/" + time.Now().Format("150405.000000") + "/"
+	defer cli.Delete(ctx, prefix, clientv3.WithPrefix())
+
+	t.Run("Exclusive", func(t *testing.T) {
+		l := NewLocker(cli, lock.Prefix(prefix))
+
+		lease, err := l.TryAcquire(ctx, "job", 5*time.Second)
+		require.NoError(t, err)
+		require.Equal(t, "job", lease.Name())
+
+		_, err = l.TryAcquire(ctx, "job", 5*time.Second)
+		require.ErrorIs(t, err, lock.ErrLocked)
+
+		require.NoError(t, lease.Refresh(ctx))
+		require.NoError(t, lease.Release(ctx))
+		require.ErrorIs(t, lease.Release(ctx), lock.ErrLeaseLost)
+
+		next, err := l.TryAcquire(ctx, "job", 5*time.Second)
+		require.NoError(t, err)
+		require.Greater(t, next.Token(), lease.Token())
+		require.NoError(t, next.Release(ctx))
+	})
+
+	t.Run("AcquireWaits", func(t *testing.T) {
+		l := NewLocker(cli, lock.Prefix(prefix))
+
+		lease, err := l.Acquire(ctx, "wait", 5*time.Second)
+		require.NoError(t, err)
+
+		timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err = l.Acquire(timeout, "wait", 5*time.Second)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			<-time.After(100 * time.Millisecond)
+			lease.Release(ctx)
+		}()
+		next, err := l.Acquire(ctx, "wait", 5*time.Second)
+		require.NoError(t, err)
+		require.Greater(t, next.Token(), lease.Token())
+		require.NoError(t, next.Release(ctx))
+	})
+}
@@ -0,0 +1,84 @@
+// Package lock provides mutual exclusion across the replicas of a service.
+//
+// A Lease is held until it is released or its TTL passes without a Refresh.
+// Every lease carries a fencing token that increases with each acquisition
+// of the same lock, so that resources written by the holder can reject
+// writes of a holder whose lease has already been lost.
+package lock
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrLocked is returned by TryAcquire when the lock is held by another owner
+	ErrLocked = errors.New("lock: already held")
+	// ErrLeaseLost is returned by Refresh and Release when the lease has
+	// expired or the lock was acquired by another owner
+	ErrLeaseLost = errors.New("lock: lease lost")
+)
+
+// Locker hands out leases on named locks.
+type Locker interface {
+	// Acquire blocks until the lock is acquired or ctx is done
+	Acquire(ctx context.Context, name string, ttl time.Duration) (Lease, error)
+	// TryAcquire acquires the lock or returns ErrLocked at once
+	TryAcquire(ctx context.Context, name string, ttl time.Duration) (Lease, error)
+}
+
+// Lease is the ownership of a lock.
+type Lease interface {
+	// Name of the lock
+	Name() string
+	// Token is the fencing token of the lease
+	Token() uint64
+	// Refresh extends the lease by its TTL
+	Refresh(ctx context.Context) error
+	// Release gives up the lock
+	Release(ctx context.Context) error
+}
+
+// DefaultPrefix prefixes the keys the locks are stored at
+const DefaultPrefix = "titan/locks/"
+
+// Options represents the options for a Locker.
+type Options struct {
+	// Prefix of the keys the locks are stored at
+	Prefix string
+	// RetryInterval is how often Acquire retries a held lock when the
+	// backend cannot notify releases
+	RetryInterval time.Duration
+}
+
+// Option manipulates the Options passed.
+type Option func(o *Options)
+
+// Prefix sets the prefix of the keys the locks are stored at.
+func Prefix(p string) Option {
+	return func(o *Options) {
+		o.Prefix = p
+	}
+}
+
+// RetryInterval sets how often Acquire retries a held lock.
+func RetryInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.RetryInterval = d
+	}
+}
+
+// NewOptions returns a new options struct.
+func NewOptions(opts ...Option) Options {
+	options := Options{
+		Prefix:        DefaultPrefix,
+		RetryInterval: 100 * time.Millisecond,
+	}
+
+	for _, o := range opts {
+		o(&options)
+	}
+
+	return options
+}
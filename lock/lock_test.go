@@ -0,0 +1,108 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/cache"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheLocker(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Exclusive", func(t *testing.T) {
+		l := NewCacheLocker(cache.NewCache())
+
+		lease, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.Equal(t, "job", lease.Name())
+
+		_, err = l.TryAcquire(ctx, "job", time.Minute)
+		require.ErrorIs(t, err, ErrLocked)
+
+		require.NoError(t, lease.Release(ctx))
+		next, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token(), lease.Token())
+	})
+
+	t.Run("AcquireWaits", func(t *testing.T) {
+		l := NewCacheLocker(cache.NewCache(), RetryInterval(5*time.Millisecond))
+
+		lease, err := l.Acquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+
+		timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		_, err = l.Acquire(timeout, "job", time.Minute)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			<-time.After(20 * time.Millisecond)
+			lease.Release(ctx)
+		}()
+		next, err := l.Acquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token(), lease.Token())
+	})
+
+	t.Run("ExpiredLeaseIsLost", func(t *testing.T) {
+		l := NewCacheLocker(cache.NewCache())
+
+		lease, err := l.TryAcquire(ctx, "job", 10*time.Millisecond)
+		require.NoError(t, err)
+		require.NoError(t, lease.Refresh(ctx))
+
+		<-time.After(20 * time.Millisecond)
+		next, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+
+		require.ErrorIs(t, lease.Refresh(ctx), ErrLeaseLost)
+		require.ErrorIs(t, lease.Release(ctx), ErrLeaseLost)
+		require.NoError(t, next.Release(ctx))
+	})
+
+	t.Run("EvictedFenceKeepsIncreasing", func(t *testing.T) {
+		c := cache.NewCache(cache.MaxEntries(1))
+		l := NewCacheLocker(c)
+
+		lease, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, lease.Release(ctx))
+
+		// a bounded cache evicts the mirrored counter
+		require.NoError(t, c.Put("other", 1, 0))
+		_, _, err = c.Get(DefaultPrefix + "job.fence")
+		require.ErrorIs(t, err, cache.ErrKeyNotFound)
+
+		next, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.NoError(t, err)
+		require.Greater(t, next.Token(), lease.Token())
+	})
+
+	t.Run("CacheErrorIsNotFree", func(t *testing.T) {
+		unavailable := errors.New("connection refused")
+		l := NewCacheLocker(&failingCache{Cache: cache.NewCache(), err: unavailable})
+
+		_, err := l.TryAcquire(ctx, "job", time.Minute)
+		require.ErrorIs(t, err, unavailable)
+	})
+}
+
+// failingCache fails every Get like an unreachable remote cache
+type failingCache struct {
+	cache.Cache
+	err error
+}
+
+func (c *failingCache) Context(ctx context.Context) cache.Cache {
+	return c
+}
+
+func (c *failingCache) Get(key string) (interface{}, time.Time, error) {
+	return nil, time.Time{}, c.err
+}
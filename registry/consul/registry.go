@@ -1,7 +1,11 @@
 package consul
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/dotnetage/go-titan/config"
 	"github.com/dotnetage/go-titan/registry"
@@ -16,8 +20,18 @@ type ConsulRegistry struct {
 	srvInfo *runtime.ServiceDesc
 	logger  *zap.Logger
 	client  *api.Client
+
+	mu   sync.Mutex
+	down bool // 服务不健康时置于维护状态
 }
 
+// maintenanceReason 服务不健康时维护状态的说明
+const maintenanceReason = "gRPC服务处于NOT_SERVING状态"
+
+// deregisterAfter 健康检查持续失败多久后 Consul 注销实例，须远长于检查间隔，
+// 以免服务短暂处于 NOT_SERVING 时被整体注销
+const deregisterAfter = "10m"
+
 func NewConsulRegistry(opts ...registry.Option) registry.Registry {
 	options := registry.DefaultOptions()
 	for _, opt := range opts {
@@ -47,31 +61,72 @@ func NewConsulRegistry(opts ...registry.Option) registry.Registry {
 }
 
 func (r *ConsulRegistry) Register(node *runtime.ServiceDesc) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	r.srvInfo = node
 	agent := r.client.Agent()
 
-	r.logger.Sugar().Infof("Check 服务器地址: %v", fmt.Sprintf("%v:%v/%v", node.GetHost(), node.GetPort(), node.Name))
+	r.logger.Sugar().Infof("Check 服务器地址: %v", fmt.Sprintf("%v:%v", node.LocalIP(), node.GetPort()))
+
+	if err := agent.ServiceRegister(r.registration(node)); err != nil {
+		r.logger.Fatal("Consul 注册失败", zap.Error(err))
+		return err
+	}
+	if r.down {
+		return agent.EnableServiceMaintenance(node.ID, maintenanceReason)
+	}
+	return nil
+}
+
+// UpdateHealth 服务不健康时将实例置于维护状态，使其不再通过健康检查；
+// 恢复时重新注册实例，以防实例因检查失败已被 Consul 注销
+func (r *ConsulRegistry) UpdateHealth(serving bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.down == !serving {
+		return nil
+	}
+	r.down = !serving
+	if r.srvInfo == nil {
+		return nil
+	}
+
+	agent := r.client.Agent()
+	if !serving {
+		r.logger.Info(fmt.Sprintf("%v 服务不可用，已在Consul中标记为维护状态", r.srvInfo.Name))
+		return agent.EnableServiceMaintenance(r.srvInfo.ID, maintenanceReason)
+	}
+
+	r.logger.Info(fmt.Sprintf("%v 服务已恢复", r.srvInfo.Name))
+	if err := agent.ServiceRegister(r.registration(r.srvInfo)); err != nil {
+		return err
+	}
+	return agent.DisableServiceMaintenance(r.srvInfo.ID)
+}
 
-	// TODO: 注册后总是会检查失败
+// registration 创建服务注册信息，Consul 通过 gRPC 健康检查协议检查服务的整体状态
+func (r *ConsulRegistry) registration(node *runtime.ServiceDesc) *api.AgentServiceRegistration {
 	reg := &api.AgentServiceRegistration{
 		ID:      node.ID,        // 服务节点的名称
 		Name:    node.Name,      // 服务名称
 		Port:    node.GetPort(), // 服务端口
 		Address: node.LocalIP(), // 服务 IP
 		Tags:    node.Tags,      // // tag，可以为空
+		Meta:    r.serviceMeta(node),
 		Check: &api.AgentServiceCheck{
-			Interval: "5s",
-			TCP:      fmt.Sprintf("%s:%d", node.LocalIP(), node.GetPort()),
-			// GRPC:                           fmt.Sprintf("%s:%d/%s", node.GetHost(), node.GetPort(), node.Name),
-			DeregisterCriticalServiceAfter: "5s", // 注销时间，相当于过期时间
+			Interval:                       "5s",
+			GRPC:                           fmt.Sprintf("%s:%d", node.LocalIP(), node.GetPort()),
+			GRPCUseTLS:                     node.TLS,
+			DeregisterCriticalServiceAfter: deregisterAfter,
 		},
 	}
 
-	if err := agent.ServiceRegister(reg); err != nil {
-		r.logger.Fatal("Consul 注册失败", zap.Error(err))
-		return err
+	if node.Weight > 0 {
+		reg.Weights = &api.AgentWeights{Passing: int(node.Weight), Warning: 1}
 	}
-	return nil
+	return reg
 }
 
 // 注销已注册的服务
@@ -84,21 +139,120 @@ func (r *ConsulRegistry) Unregister() error {
 	return nil
 }
 
-// 获取注册服务器的信息
+// GetServices 获取与当前注册服务同名的所有实例
 func (r *ConsulRegistry) GetServices() ([]*runtime.ServiceDesc, error) {
-	catalogService, _, _ := r.client.Catalog().Service(r.srvInfo.Name, "", nil)
-	if len(catalogService) > 0 {
-		result := make([]*runtime.ServiceDesc, len(catalogService))
-		for index, server := range catalogService {
-			s := &runtime.ServiceDesc{
-				ID:       server.ServiceID,
-				Name:     server.ServiceName,
-				EndPoint: *config.NewEndpoint(fmt.Sprintf("%s:%v", server.Address, server.ServicePort)),
-				// Metadata: sever.ServiceMeta,
-			}
-			result[index] = s
+	if r.srvInfo == nil {
+		return nil, errors.New("服务未注册")
+	}
+	return r.GetService(r.srvInfo.Name, "")
+}
+
+// ListServices 获取所有服务通过健康检查的实例，不包括 Consul 自身
+func (r *ConsulRegistry) ListServices() ([]*runtime.ServiceDesc, error) {
+	names, _, err := r.client.Catalog().Services(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	svcs := make([]*runtime.ServiceDesc, 0)
+	for _, name := range sortedNames(names) {
+		if name == "consul" {
+			continue
+		}
+		instances, err := r.GetService(name, "")
+		if err != nil {
+			return nil, err
+		}
+		svcs = append(svcs, instances...)
+	}
+	return svcs, nil
+}
+
+// GetService 获取指定服务通过健康检查的实例，version 为空时返回所有版本
+func (r *ConsulRegistry) GetService(name, version string) ([]*runtime.ServiceDesc, error) {
+	entries, _, err := r.client.Health().Service(name, "", true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	svcs := make([]*runtime.ServiceDesc, 0, len(entries))
+	for _, entry := range entries {
+		svc := toServiceDesc(entry)
+		if version == "" || svc.Version == version {
+			svcs = append(svcs, svc)
+		}
+	}
+	return svcs, nil
+}
+
+// toServiceDesc 将 Consul 的服务实例转换为 ServiceDesc，版本保存在服务元数据中
+func toServiceDesc(entry *api.ServiceEntry) *runtime.ServiceDesc {
+	addr := entry.Service.Address
+	if addr == "" && entry.Node != nil {
+		addr = entry.Node.Address
+	}
+	return &runtime.ServiceDesc{
+		ID:       entry.Service.ID,
+		Name:     entry.Service.Service,
+		EndPoint: *config.NewEndpoint(fmt.Sprintf("%s:%v", addr, entry.Service.Port)),
+		Version:  entry.Service.Meta["version"],
+		Weight:   int64(entry.Service.Weights.Passing),
+		Tags:     entry.Service.Tags,
+		Metadata: entry.Service.Meta,
+	}
+}
+
+func sortedNames(services map[string][]string) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxMetaKeyLength Consul 服务元数据键的最大长度
+const maxMetaKeyLength = 128
+
+// serviceMeta 将服务版本与元数据写入 Consul 的服务元数据。
+// Consul 只接受由字母、数字、下划线与连字符组成的键，其他字符替换为下划线；
+// 过长、以 consul- 开头或替换后与其他键重复的键将被忽略，以免注册失败
+func (r *ConsulRegistry) serviceMeta(node *runtime.ServiceDesc) map[string]string {
+	meta := make(map[string]string, len(node.Metadata)+1)
+	for k, v := range node.Metadata {
+		if validMetaKey(k) {
+			meta[k] = v
 		}
-		return result, nil
 	}
-	return nil, nil
+	for k, v := range node.Metadata {
+		if validMetaKey(k) {
+			continue
+		}
+		key := metaKey(k)
+		if _, ok := meta[key]; ok || !validMetaKey(key) {
+			r.logger.Warn("忽略无效的服务元数据键", zap.String("key", k))
+			continue
+		}
+		meta[key] = v
+	}
+	if node.Version != "" {
+		meta["version"] = node.Version
+	}
+	return meta
+}
+
+// validMetaKey 检查键能否被 Consul 接受
+func validMetaKey(k string) bool {
+	return k != "" && len(k) <= maxMetaKeyLength && !strings.HasPrefix(k, "consul-") && metaKey(k) == k
+}
+
+// metaKey 将键中 Consul 不接受的字符替换为下划线
+func metaKey(k string) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			return c
+		}
+		return '_'
+	}, k)
 }
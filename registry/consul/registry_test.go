@@ -1,7 +1,301 @@
 package consul
 
-import "testing"
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/config"
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
 
 func TestConsulRegistryRegister(t *testing.T) {
+	catalog := newFakeCatalog()
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	reg := NewConsulRegistry(registry.WithEndPoints(strings.TrimPrefix(srv.URL, "http://")))
+	node := &runtime.ServiceDesc{ID: "greeter-1", Name: "greeter", Version: "v1", EndPoint: *config.NewEndpoint("127.0.0.1:9001")}
+	require.NoError(t, reg.Register(node))
+
+	updater := reg.(registry.HealthUpdater)
+	require.NoError(t, updater.UpdateHealth(false))
+	require.NoError(t, updater.UpdateHealth(false))
+	require.NoError(t, updater.UpdateHealth(true))
+
+	catalog.Lock()
+	defer catalog.Unlock()
+	require.Equal(t, []string{
+		"/v1/agent/service/register",
+		"/v1/agent/service/maintenance/greeter-1?enable=true",
+		"/v1/agent/service/register",
+		"/v1/agent/service/maintenance/greeter-1?enable=false",
+	}, catalog.agent)
+
+	check := catalog.registrations[0].Check
+	require.NotEmpty(t, check.GRPC)
+	require.Empty(t, check.TCP)
+	require.Equal(t, deregisterAfter, check.DeregisterCriticalServiceAfter)
+	require.Equal(t, "v1", catalog.registrations[0].Meta["version"])
+}
+
+// fakeCatalog 模拟Consul健康检查接口的阻塞查询
+type fakeCatalog struct {
+	sync.Mutex
+	index   uint64
+	entries []*api.ServiceEntry
+	changed chan struct{}
+	// agent 记录对 Agent 接口的调用
+	agent         []string
+	registrations []*api.AgentServiceRegistration
+}
+
+func newFakeCatalog() *fakeCatalog {
+	return &fakeCatalog{index: 1, changed: make(chan struct{})}
+}
+
+func (c *fakeCatalog) set(services ...*api.AgentService) {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = nil
+	for _, svc := range services {
+		c.entries = append(c.entries, &api.ServiceEntry{Node: &api.Node{Address: "10.0.0.1"}, Service: svc})
+	}
+	c.index++
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *fakeCatalog) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.HasPrefix(req.URL.Path, "/v1/agent/") {
+		c.Lock()
+		defer c.Unlock()
+		call := req.URL.Path
+		if enable := req.URL.Query().Get("enable"); enable != "" {
+			call += "?enable=" + enable
+		}
+		c.agent = append(c.agent, call)
+		if strings.HasSuffix(req.URL.Path, "/register") {
+			reg := &api.AgentServiceRegistration{}
+			json.NewDecoder(req.Body).Decode(reg)
+			c.registrations = append(c.registrations, reg)
+		}
+		return
+	}
+	if req.URL.Path == "/v1/catalog/services" {
+		c.Lock()
+		services := map[string][]string{"consul": {}}
+		for _, entry := range c.entries {
+			services[entry.Service.Service] = entry.Service.Tags
+		}
+		c.Unlock()
+		w.Header().Set("X-Consul-Index", "1")
+		json.NewEncoder(w).Encode(services)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, "/v1/health/service/") {
+		http.NotFound(w, req)
+		return
+	}
+	name := strings.TrimPrefix(req.URL.Path, "/v1/health/service/")
+	index, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+
+	c.Lock()
+	if index >= c.index {
+		changed := c.changed
+		c.Unlock()
+		select {
+		case <-changed:
+		case <-req.Context().Done():
+			return
+		case <-time.After(time.Second):
+		}
+		c.Lock()
+	}
+	entries := []*api.ServiceEntry{}
+	for _, entry := range c.entries {
+		if entry.Service.Service == name {
+			entries = append(entries, entry)
+		}
+	}
+	current := c.index
+	c.Unlock()
+
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(current, 10))
+	json.NewEncoder(w).Encode(entries)
+}
+
+func TestConsulRegistryWatch(t *testing.T) {
+	catalog := newFakeCatalog()
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	reg := NewConsulRegistry(registry.WithEndPoints(strings.TrimPrefix(srv.URL, "http://")))
+	catalog.set(&api.AgentService{ID: "greeter-1", Service: "greeter", Port: 9001})
+
+	w, err := reg.Watch("greeter")
+	require.NoError(t, err)
+	defer w.Stop()
+
+	next := func(action, id string) *runtime.ServiceDesc {
+		result, err := w.Next()
+		require.NoError(t, err)
+		require.Equal(t, action, result.Action)
+		svc := result.Data.(*runtime.ServiceDesc)
+		require.Equal(t, id, svc.ID)
+		return svc
+	}
+
+	svc := next(registry.ActionCreate, "greeter-1")
+	require.Equal(t, "10.0.0.1:9001", svc.Addr)
+
+	go catalog.set(
+		&api.AgentService{ID: "greeter-1", Service: "greeter", Port: 9001, Tags: []string{"canary"}},
+		&api.AgentService{ID: "greeter-2", Service: "greeter", Port: 9002},
+	)
+	require.Equal(t, []string{"canary"}, next(registry.ActionUpdate, "greeter-1").Tags)
+	next(registry.ActionCreate, "greeter-2")
+
+	go catalog.set(&api.AgentService{ID: "greeter-2", Service: "greeter", Port: 9002})
+	next(registry.ActionDelete, "greeter-1")
+
+	go func() {
+		<-time.After(20 * time.Millisecond)
+		w.Stop()
+	}()
+	_, err = w.Next()
+	require.ErrorIs(t, err, registry.ErrWatcherStopped)
+}
+
+func TestConsulRegistryListServices(t *testing.T) {
+	catalog := newFakeCatalog()
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	catalog.set(
+		&api.AgentService{ID: "greeter-1", Service: "greeter", Port: 9001, Tags: []string{"canary"},
+			Meta: map[string]string{"version": "v2", "zone": "a"}},
+		&api.AgentService{ID: "greeter-2", Service: "greeter", Port: 9002, Meta: map[string]string{"version": "v1"}},
+		&api.AgentService{ID: "orders-1", Service: "orders", Port: 9101},
+	)
+	reg := NewConsulRegistry(registry.WithEndPoints(strings.TrimPrefix(srv.URL, "http://")))
+
+	svcs, err := reg.ListServices()
+	require.NoError(t, err)
+	require.Len(t, svcs, 3)
+	require.Equal(t, "greeter-1", svcs[0].ID)
+	require.Equal(t, []string{"canary"}, svcs[0].Tags)
+	require.Equal(t, "a", svcs[0].Metadata["zone"])
+	require.Equal(t, "orders", svcs[2].Name)
+
+	svcs, err = reg.GetService("greeter", "v1")
+	require.NoError(t, err)
+	require.Len(t, svcs, 1)
+	require.Equal(t, "greeter-2", svcs[0].ID)
+
+	_, err = reg.GetServices()
+	require.Error(t, err)
+}
+
+// fakeClientConn 记录解析器更新的连接状态
+type fakeClientConn struct {
+	resolver.ClientConn
+	states chan resolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	cc.states <- state
+	return nil
+}
+
+func (cc *fakeClientConn) ReportError(err error) {}
+
+func TestConsulResolver(t *testing.T) {
+	catalog := newFakeCatalog()
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	catalog.set(
+		&api.AgentService{ID: "greeter-1", Service: "greeter", Address: "127.0.0.1", Port: 9001,
+			Tags: []string{"canary"}, Meta: map[string]string{"version": "v2"}, Weights: api.AgentWeights{Passing: 5}},
+		&api.AgentService{ID: "greeter-2", Service: "greeter", Address: "127.0.0.1", Port: 9002,
+			Meta: map[string]string{"version": "v1"}},
+	)
+
+	builder := NewResolver(strings.TrimPrefix(srv.URL, "http://"), zap.NewNop())
+	require.Equal(t, "consul", builder.Scheme())
+
+	t.Run("Attributes", func(t *testing.T) {
+		cc := &fakeClientConn{states: make(chan resolver.State, 10)}
+		r, err := builder.Build(resolver.Target{Scheme: "consul", Endpoint: "greeter"}, cc, resolver.BuildOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+
+		state := <-cc.states
+		require.Len(t, state.Addresses, 2)
+		canary := state.Addresses[0]
+		require.Equal(t, "127.0.0.1:9001", canary.Addr)
+		require.Equal(t, int64(5), runtime.AddressWeight(canary))
+		require.Equal(t, []string{"canary"}, runtime.AddressTags(canary))
+		require.Equal(t, "v2", runtime.AddressVersion(canary))
+
+		// 实例未通过健康检查时不再出现在连接状态中
+		catalog.set(&api.AgentService{ID: "greeter-2", Service: "greeter", Address: "127.0.0.1", Port: 9002})
+		state = <-cc.states
+		require.Len(t, state.Addresses, 1)
+		require.Equal(t, "127.0.0.1:9002", state.Addresses[0].Addr)
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		catalog.set(
+			&api.AgentService{ID: "greeter-1", Service: "greeter", Address: "127.0.0.1", Port: 9001, Meta: map[string]string{"version": "v2"}},
+			&api.AgentService{ID: "greeter-2", Service: "greeter", Address: "127.0.0.1", Port: 9002, Meta: map[string]string{"version": "v1"}},
+		)
+		cc := &fakeClientConn{states: make(chan resolver.State, 10)}
+		r, err := builder.Build(resolver.Target{Scheme: "consul", Authority: "v1", Endpoint: "greeter"}, cc, resolver.BuildOptions{})
+		require.NoError(t, err)
+		defer r.Close()
+
+		state := <-cc.states
+		require.Len(t, state.Addresses, 1)
+		require.Equal(t, "127.0.0.1:9002", state.Addresses[0].Addr)
+	})
+}
+
+func TestConsulRegistryMetadata(t *testing.T) {
+	catalog := newFakeCatalog()
+	srv := httptest.NewServer(catalog)
+	defer srv.Close()
+
+	reg := NewConsulRegistry(registry.WithEndPoints(strings.TrimPrefix(srv.URL, "http://")))
+	node := &runtime.ServiceDesc{ID: "greeter-1", Name: "greeter", Version: "v1", EndPoint: *config.NewEndpoint("127.0.0.1:9001"),
+		Metadata: map[string]string{
+			"zone":                   "a",
+			"app.kubernetes.io/name": "greeter",
+			"team_name":              "core",
+			"team.name":              "other",
+			"consul-internal":        "x",
+			strings.Repeat("k", 129): "long",
+		}}
+	require.NoError(t, reg.Register(node))
 
+	catalog.Lock()
+	defer catalog.Unlock()
+	require.Equal(t, map[string]string{
+		"version":                "v1",
+		"zone":                   "a",
+		"app_kubernetes_io_name": "greeter",
+		"team_name":              "core",
+	}, catalog.registrations[0].Meta)
 }
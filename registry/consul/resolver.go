@@ -0,0 +1,143 @@
+package consul
+
+import (
+	"context"
+	"time"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/hashicorp/consul/api"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+const (
+	schema = "consul"
+
+	// retryInterval 查询失败后重试的间隔
+	retryInterval = time.Second
+)
+
+// ConsulResolver for grpc client
+//
+// 通过 Consul 健康检查接口的阻塞查询获取通过健康检查的服务实例，
+// 目标地址的格式为 consul://[version]/name
+type ConsulResolver struct {
+	addr    string
+	options *registry.ResolverOptions
+	logger  *zap.Logger
+}
+
+// NewResolver create a new resolver.Builder base on consul
+func NewResolver(addr string, logger *zap.Logger, opts ...registry.ResolverOption) *ConsulResolver {
+	return &ConsulResolver{
+		addr:    addr,
+		options: registry.NewResolverOptions(opts...),
+		logger:  logger,
+	}
+}
+
+// Scheme returns the scheme supported by this resolver.
+func (b *ConsulResolver) Scheme() string {
+	return schema
+}
+
+// Build creates a new resolver.Resolver for the given target
+func (b *ConsulResolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	cfg := api.DefaultConfig()
+	cfg.Address = b.addr
+	client, err := api.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &consulResolver{
+		client:  client,
+		cc:      cc,
+		name:    target.Endpoint,
+		version: target.Authority,
+		options: b.options,
+		ctx:     ctx,
+		cancel:  cancel,
+		logger:  b.logger,
+	}
+
+	// 首次查询失败时直接返回错误
+	if err := r.resolve(); err != nil {
+		cancel()
+		return nil, err
+	}
+	go r.watch()
+	return r, nil
+}
+
+type consulResolver struct {
+	client  *api.Client
+	cc      resolver.ClientConn
+	name    string
+	version string
+	options *registry.ResolverOptions
+	index   uint64
+	ctx     context.Context
+	cancel  context.CancelFunc
+	logger  *zap.Logger
+}
+
+// ResolveNow resolver.Resolver interface
+func (r *consulResolver) ResolveNow(o resolver.ResolveNowOptions) {}
+
+// Close resolver.Resolver interface
+func (r *consulResolver) Close() {
+	r.cancel()
+}
+
+// watch 持续进行阻塞查询直至解析器关闭
+func (r *consulResolver) watch() {
+	for {
+		err := r.resolve()
+		if r.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.logger.Error("查询Consul服务实例失败", zap.String("service", r.name), zap.Error(err))
+			r.cc.ReportError(err)
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// resolve 阻塞至服务实例发生变化后更新连接状态
+func (r *consulResolver) resolve() error {
+	q := (&api.QueryOptions{WaitIndex: r.index, WaitTime: watchWaitTime}).WithContext(r.ctx)
+	entries, meta, err := r.client.Health().Service(r.name, "", true, q)
+	if err != nil {
+		return err
+	}
+
+	// 索引未变化说明阻塞查询超时，实例没有变化
+	if meta.LastIndex == r.index {
+		return nil
+	}
+	if meta.LastIndex < r.index {
+		r.index = 0
+	} else {
+		r.index = meta.LastIndex
+	}
+
+	addrs := make([]resolver.Address, 0, len(entries))
+	for _, entry := range entries {
+		info := toServiceDesc(entry)
+		if (r.version != "" && info.Version != r.version) || !r.options.Match(info) {
+			continue
+		}
+		addrs = append(addrs, runtime.NewAddress(info))
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+	return nil
+}
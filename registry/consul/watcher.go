@@ -0,0 +1,106 @@
+package consul
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// watchWaitTime 阻塞查询的最长等待时间
+const watchWaitTime = 5 * time.Minute
+
+// Watch 通过阻塞查询监视指定服务通过健康检查的实例，
+// 实例健康检查失败时视为删除，恢复后视为新建
+func (r *ConsulRegistry) Watch(serviceName string) (runtime.Watcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &consulWatcher{
+		client:  r.client,
+		name:    serviceName,
+		ctx:     ctx,
+		cancel:  cancel,
+		current: make(map[string]*runtime.ServiceDesc),
+	}, nil
+}
+
+type consulWatcher struct {
+	client  *api.Client
+	name    string
+	ctx     context.Context
+	cancel  context.CancelFunc
+	index   uint64
+	current map[string]*runtime.ServiceDesc
+	pending []runtime.Result
+}
+
+// Next 阻塞直至有新的变更，监视器停止后返回 registry.ErrWatcherStopped
+func (w *consulWatcher) Next() (runtime.Result, error) {
+	for len(w.pending) == 0 {
+		q := (&api.QueryOptions{WaitIndex: w.index, WaitTime: watchWaitTime}).WithContext(w.ctx)
+		entries, meta, err := w.client.Health().Service(w.name, "", true, q)
+		if w.ctx.Err() != nil {
+			return runtime.Result{}, registry.ErrWatcherStopped
+		}
+		if err != nil {
+			return runtime.Result{}, err
+		}
+
+		// 索引回退时需重新开始阻塞查询
+		if meta.LastIndex < w.index {
+			w.index = 0
+		} else {
+			w.index = meta.LastIndex
+		}
+
+		services := make(map[string]*runtime.ServiceDesc, len(entries))
+		for _, entry := range entries {
+			svc := toServiceDesc(entry)
+			services[svc.ID] = svc
+		}
+		w.pending = diff(w.current, services)
+		w.current = services
+	}
+
+	result := w.pending[0]
+	w.pending = w.pending[1:]
+	return result, nil
+}
+
+// Stop 停止监视
+func (w *consulWatcher) Stop() {
+	w.cancel()
+}
+
+// diff 比较前后两次查询的实例，按服务ID排序返回变更
+func diff(prev, next map[string]*runtime.ServiceDesc) []runtime.Result {
+	var results []runtime.Result
+	for _, id := range sortedKeys(next) {
+		old, ok := prev[id]
+		switch {
+		case !ok:
+			results = append(results, runtime.Result{Action: registry.ActionCreate, Data: next[id]})
+		case !reflect.DeepEqual(old, next[id]):
+			results = append(results, runtime.Result{Action: registry.ActionUpdate, Data: next[id]})
+		}
+	}
+	for _, id := range sortedKeys(prev) {
+		if _, ok := next[id]; !ok {
+			results = append(results, runtime.Result{Action: registry.ActionDelete, Data: prev[id]})
+		}
+	}
+	return results
+}
+
+func sortedKeys(m map[string]*runtime.ServiceDesc) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
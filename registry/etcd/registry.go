@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dotnetage/go-titan/registry"
@@ -28,6 +29,9 @@ type ETCDRegistry struct {
 	srvTTL  int
 	cli     *clientv3.Client
 	logger  *zap.Logger
+
+	mu   sync.Mutex
+	down bool // 服务不健康时保留租约但删除节点
 }
 
 // NewRegister create a register base on etcd
@@ -49,10 +53,7 @@ func (r *ETCDRegistry) Register(srvInfo *runtime.ServiceDesc) error {
 		return errors.New("无效的IP")
 	}
 
-	if r.cli, err = clientv3.New(clientv3.Config{
-		Endpoints:   r.options.Endpoints,
-		DialTimeout: time.Duration(r.options.DialTimeout) * time.Second,
-	}); err != nil {
+	if err = r.connect(); err != nil {
 		return err
 	}
 
@@ -63,7 +64,9 @@ func (r *ETCDRegistry) Register(srvInfo *runtime.ServiceDesc) error {
 		return err
 	}
 	r.logger.Info(fmt.Sprintf("%v 服务已成功注册", srvInfo.Name))
+	r.mu.Lock()
 	r.closeCh = make(chan struct{})
+	r.mu.Unlock()
 
 	go r.keepAlive()
 
@@ -105,25 +108,79 @@ func (r *ETCDRegistry) Unregister() error {
 // 	})
 // }
 
+// GetServices 获取与当前注册服务同名的所有实例
 func (r *ETCDRegistry) GetServices() ([]*runtime.ServiceDesc, error) {
-	resp, err := r.cli.Get(context.Background(), runtime.BuildRegPath(r.srvInfo))
-	if err != nil {
-		return []*runtime.ServiceDesc{r.srvInfo}, err
+	if r.srvInfo == nil {
+		return nil, errors.New("服务未注册")
 	}
+	return r.GetService(r.srvInfo.Name, "")
+}
+
+// ListServices 获取所有服务的全部实例。
+// 设置了 KeyPrefix 时只查询该前缀下的键；否则服务键位于根路径下，
+// 只保留键与服务信息一致的实例，忽略其他应用写入的键
+func (r *ETCDRegistry) ListServices() ([]*runtime.ServiceDesc, error) {
+	return r.list(r.options.KeyPrefix + "/")
+}
+
+// GetService 获取指定服务的实例，version 为空时返回所有版本
+func (r *ETCDRegistry) GetService(name, version string) ([]*runtime.ServiceDesc, error) {
+	return r.list(r.prefix(&runtime.ServiceDesc{Name: name, Version: version}))
+}
 
-	svcs := make([]*runtime.ServiceDesc, 0)
-	count := int(resp.Count)
+// prefix 服务实例键的前缀
+func (r *ETCDRegistry) prefix(info *runtime.ServiceDesc) string {
+	return r.options.KeyPrefix + runtime.BuildPrefix(info)
+}
+
+// key 服务实例的键
+func (r *ETCDRegistry) key(info *runtime.ServiceDesc) string {
+	return r.options.KeyPrefix + runtime.BuildRegPath(info)
+}
 
-	for i := 0; i < count; i++ {
-		svc := runtime.ServiceDesc{}
-		if err := json.Unmarshal(resp.Kvs[i].Value, &svc); err != nil {
-			return svcs, err
+// list 按前缀查询服务实例
+func (r *ETCDRegistry) list(prefix string) ([]*runtime.ServiceDesc, error) {
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.options.DialTimeout)*time.Second)
+	defer cancel()
+	resp, err := r.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+
+	svcs := make([]*runtime.ServiceDesc, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		svc, err := runtime.ParseValue(kv.Value)
+		if err != nil || svc.Name == "" || string(kv.Key) != r.key(&svc) {
+			continue
 		}
 		svcs = append(svcs, &svc)
 	}
 	return svcs, nil
 }
 
+// connect 创建ETCD客户端，注册与监视共用同一个客户端，并发调用时只创建一次
+func (r *ETCDRegistry) connect() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.cli != nil {
+		return nil
+	}
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   r.options.Endpoints,
+		DialTimeout: time.Duration(r.options.DialTimeout) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	r.cli = cli
+	return nil
+}
+
 // register 注册节点
 func (r *ETCDRegistry) register() error {
 	leaseCtx, cancel := context.WithTimeout(context.Background(), time.Duration(r.options.DialTimeout)*time.Second)
@@ -133,22 +190,65 @@ func (r *ETCDRegistry) register() error {
 	if err != nil {
 		return err
 	}
-	r.leasesID = leaseResp.ID
-	if r.keepAliveCh, err = r.cli.KeepAlive(context.Background(), leaseResp.ID); err != nil {
+	keepAliveCh, err := r.cli.KeepAlive(context.Background(), leaseResp.ID)
+	if err != nil {
 		return err
 	}
 
+	// UpdateHealth 在持有锁时以当前租约写入节点
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leasesID = leaseResp.ID
+	r.keepAliveCh = keepAliveCh
+	if r.down {
+		return nil
+	}
+	return r.put()
+}
+
+// put 写入节点，须在持有锁时调用
+func (r *ETCDRegistry) put() error {
 	data, err := json.Marshal(r.srvInfo)
 	if err != nil {
 		return err
 	}
-	_, err = r.cli.Put(context.Background(), runtime.BuildRegPath(r.srvInfo), string(data), clientv3.WithLease(r.leasesID))
+	_, err = r.cli.Put(context.Background(), r.key(r.srvInfo), string(data), clientv3.WithLease(r.leasesID))
 	return err
 }
 
+// UpdateHealth 服务不健康时删除节点使其不再被发现，恢复后以原有的租约重新写入。
+// 写入失败时状态保持不变，再次调用时重试
+func (r *ETCDRegistry) UpdateHealth(serving bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.down == !serving {
+		return nil
+	}
+	if r.closeCh == nil {
+		r.down = !serving
+		return nil
+	}
+
+	if serving {
+		if err := r.put(); err != nil {
+			return err
+		}
+		r.down = false
+		r.logger.Info(fmt.Sprintf("%v 服务已恢复", r.srvInfo.Name))
+		return nil
+	}
+	if err := r.unregister(); err != nil {
+		return err
+	}
+	r.down = true
+	r.logger.Info(fmt.Sprintf("%v 服务不可用，已从注册中心下线", r.srvInfo.Name))
+	return nil
+}
+
 // unregister 删除节点
 func (r *ETCDRegistry) unregister() error {
-	_, err := r.cli.Delete(context.Background(), runtime.BuildRegPath(r.srvInfo))
+	_, err := r.cli.Delete(context.Background(), r.key(r.srvInfo))
 	return err
 }
 
@@ -0,0 +1,127 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/config"
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// testEndpoints 返回 ETCD_ENDPOINTS 中的etcd集群地址，默认为 127.0.0.1:2379，
+// 无法连接时跳过测试
+func testEndpoints(t *testing.T) []string {
+	endpoints := []string{"127.0.0.1:2379"}
+	if v := os.Getenv("ETCD_ENDPOINTS"); len(v) > 0 {
+		endpoints = strings.Split(v, ",")
+	}
+
+	cli, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: time.Second})
+	if err != nil {
+		t.Skipf("etcd is not available: %v", err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cli.Status(ctx, endpoints[0]); err != nil {
+		t.Skipf("etcd is not available: %v", err)
+	}
+	return endpoints
+}
+
+// newTestRegistry 创建使用独立键前缀的注册中心，测试结束后删除前缀下的全部键
+func newTestRegistry(t *testing.T, endpoints []string, prefix string) *ETCDRegistry {
+	reg := NewETCDRegistry(registry.WithEndPoints(endpoints...), registry.WithKeyPrefix(prefix)).(*ETCDRegistry)
+	require.NoError(t, reg.connect())
+	t.Cleanup(func() {
+		reg.cli.Delete(context.Background(), prefix+"/", clientv3.WithPrefix())
+		reg.cli.Close()
+	})
+	return reg
+}
+
+func newTestDesc(id, name, addr string) *runtime.ServiceDesc {
+	return &runtime.ServiceDesc{ID: id, Name: name, Version: "v1", EndPoint: *config.NewEndpoint(addr)}
+}
+
+func TestETCDRegistry(t *testing.T) {
+	endpoints := testEndpoints(t)
+	prefix := fmt.Sprintf("/titan-test/%d", time.Now().UnixNano())
+
+	t.Run("ListServices", func(t *testing.T) {
+		greeter := newTestRegistry(t, endpoints, prefix)
+		orders := newTestRegistry(t, endpoints, prefix)
+		require.NoError(t, greeter.Register(newTestDesc("greeter-1", "greeter", "127.0.0.1:9001")))
+		require.NoError(t, orders.Register(newTestDesc("orders-1", "orders", "127.0.0.1:9101")))
+
+		// 前缀之外的键不属于注册中心
+		_, err := greeter.cli.Put(context.Background(), "/greeter/v1/127.0.0.1:9001", `{"name":"greeter"}`)
+		require.NoError(t, err)
+		defer greeter.cli.Delete(context.Background(), "/greeter/v1/127.0.0.1:9001")
+
+		svcs, err := greeter.ListServices()
+		require.NoError(t, err)
+		require.Len(t, svcs, 2)
+		names := []string{svcs[0].Name, svcs[1].Name}
+		require.ElementsMatch(t, []string{"greeter", "orders"}, names)
+
+		svcs, err = greeter.GetService("orders", "v1")
+		require.NoError(t, err)
+		require.Len(t, svcs, 1)
+		require.Equal(t, "orders-1", svcs[0].ID)
+
+		require.NoError(t, greeter.Unregister())
+		require.NoError(t, orders.Unregister())
+		require.Eventually(t, func() bool {
+			svcs, err := greeter.ListServices()
+			return err == nil && len(svcs) == 0
+		}, 3*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("WatchAndUpdateHealth", func(t *testing.T) {
+		reg := newTestRegistry(t, endpoints, prefix)
+		w, err := reg.Watch("greeter")
+		require.NoError(t, err)
+		defer w.Stop()
+
+		next := func(action string) *runtime.ServiceDesc {
+			result, err := w.Next()
+			require.NoError(t, err)
+			require.Equal(t, action, result.Action)
+			return result.Data.(*runtime.ServiceDesc)
+		}
+
+		require.NoError(t, reg.Register(newTestDesc("greeter-1", "greeter", "127.0.0.1:9001")))
+		require.Equal(t, "greeter-1", next(registry.ActionCreate).ID)
+
+		// 不健康时节点被删除，恢复后重新写入
+		require.NoError(t, reg.UpdateHealth(false))
+		require.Equal(t, "greeter-1", next(registry.ActionDelete).ID)
+		svcs, err := reg.GetService("greeter", "")
+		require.NoError(t, err)
+		require.Empty(t, svcs)
+
+		require.NoError(t, reg.UpdateHealth(false))
+		require.NoError(t, reg.UpdateHealth(true))
+		require.Equal(t, "greeter-1", next(registry.ActionCreate).ID)
+
+		require.NoError(t, reg.Unregister())
+		require.Equal(t, "greeter-1", next(registry.ActionDelete).ID)
+
+		go func() {
+			<-time.After(20 * time.Millisecond)
+			w.Stop()
+		}()
+		_, err = w.Next()
+		require.ErrorIs(t, err, registry.ErrWatcherStopped)
+	})
+}
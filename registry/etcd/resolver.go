@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/dotnetage/go-titan/registry"
 	"github.com/dotnetage/go-titan/runtime"
 
 	"go.uber.org/zap"
@@ -31,16 +32,18 @@ type ETCDResolver struct {
 	keyPrifix    string
 	srvAddrsList []resolver.Address
 
-	cc     resolver.ClientConn
-	logger *zap.Logger
+	cc      resolver.ClientConn
+	options *registry.ResolverOptions
+	logger  *zap.Logger
 }
 
 // NewResolver create a new resolver.Builder base on etcd
-func NewResolver(etcdAddrs []string, logger *zap.Logger) *ETCDResolver {
+func NewResolver(etcdAddrs []string, logger *zap.Logger, opts ...registry.ResolverOption) *ETCDResolver {
 	return &ETCDResolver{
 		schema:      schema,
 		EtcdAddrs:   etcdAddrs,
 		DialTimeout: 3,
+		options:     registry.NewResolverOptions(opts...),
 		logger:      logger,
 	}
 }
@@ -54,7 +57,7 @@ func (r *ETCDResolver) Scheme() string {
 func (r *ETCDResolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
 	r.cc = cc
 
-	r.keyPrifix = runtime.BuildPrefix(&runtime.ServiceDesc{Name: target.Endpoint, Version: target.Authority})
+	r.keyPrifix = r.options.KeyPrefix + runtime.BuildPrefix(&runtime.ServiceDesc{Name: target.Endpoint, Version: target.Authority})
 	if _, err := r.start(); err != nil {
 		return nil, err
 	}
@@ -125,11 +128,18 @@ func (r *ETCDResolver) update(events []*clientv3.Event) {
 			if err != nil {
 				continue
 			}
-			addr := resolver.Address{Addr: info.Addr, Metadata: info.Weight}
-			if !runtime.Exist(r.srvAddrsList, addr) {
+			// 实例信息变化后可能不再满足过滤条件，先移除旧的地址
+			addr := runtime.NewAddress(&info)
+			s, removed := runtime.Remove(r.srvAddrsList, addr)
+			if removed {
+				r.srvAddrsList = s
+			}
+			if r.options.Match(&info) {
 				r.srvAddrsList = append(r.srvAddrsList, addr)
-				r.cc.UpdateState(resolver.State{Addresses: r.srvAddrsList})
+			} else if !removed {
+				continue
 			}
+			r.cc.UpdateState(resolver.State{Addresses: r.srvAddrsList})
 		case mvccpb.DELETE:
 			info, err = runtime.SplitPath(string(ev.Kv.Key))
 			if err != nil {
@@ -159,8 +169,10 @@ func (r *ETCDResolver) sync() error {
 		if err != nil {
 			continue
 		}
-		addr := resolver.Address{Addr: info.Addr, Metadata: info.Weight}
-		r.srvAddrsList = append(r.srvAddrsList, addr)
+		if !r.options.Match(&info) {
+			continue
+		}
+		r.srvAddrsList = append(r.srvAddrsList, runtime.NewAddress(&info))
 	}
 	r.cc.UpdateState(resolver.State{Addresses: r.srvAddrsList})
 	return nil
@@ -0,0 +1,102 @@
+package etcd
+
+import (
+	"context"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// Watch 监视指定服务所有版本的实例变更
+func (r *ETCDRegistry) Watch(serviceName string) (runtime.Watcher, error) {
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+
+	prefix := r.prefix(&runtime.ServiceDesc{Name: serviceName})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 先读取现有实例，再从其后的版本开始监视，避免遗漏两者之间的变更
+	resp, err := r.cli.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	w := &etcdWatcher{
+		ctx:    ctx,
+		cancel: cancel,
+		events: r.cli.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithPrevKV(), clientv3.WithRev(resp.Header.Revision+1)),
+	}
+	for _, kv := range resp.Kvs {
+		if info, err := runtime.ParseValue(kv.Value); err == nil {
+			w.pending = append(w.pending, runtime.Result{Action: registry.ActionCreate, Data: &info})
+		}
+	}
+	return w, nil
+}
+
+type etcdWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	events  clientv3.WatchChan
+	pending []runtime.Result
+}
+
+// Next 阻塞直至有新的变更，监视器停止后返回 registry.ErrWatcherStopped
+func (w *etcdWatcher) Next() (runtime.Result, error) {
+	for len(w.pending) == 0 {
+		resp, ok := <-w.events
+		if !ok || w.ctx.Err() != nil {
+			return runtime.Result{}, registry.ErrWatcherStopped
+		}
+		if err := resp.Err(); err != nil {
+			return runtime.Result{}, err
+		}
+		for _, ev := range resp.Events {
+			if result, ok := toResult(ev); ok {
+				w.pending = append(w.pending, result)
+			}
+		}
+	}
+
+	result := w.pending[0]
+	w.pending = w.pending[1:]
+	return result, nil
+}
+
+// Stop 停止监视
+func (w *etcdWatcher) Stop() {
+	w.cancel()
+}
+
+func toResult(ev *clientv3.Event) (runtime.Result, bool) {
+	switch ev.Type {
+	case mvccpb.PUT:
+		info, err := runtime.ParseValue(ev.Kv.Value)
+		if err != nil {
+			return runtime.Result{}, false
+		}
+		action := registry.ActionUpdate
+		if ev.IsCreate() {
+			action = registry.ActionCreate
+		}
+		return runtime.Result{Action: action, Data: &info}, true
+	case mvccpb.DELETE:
+		// 租约过期删除的节点只能从之前的值中解析完整的服务信息
+		if ev.PrevKv != nil {
+			if info, err := runtime.ParseValue(ev.PrevKv.Value); err == nil {
+				return runtime.Result{Action: registry.ActionDelete, Data: &info}, true
+			}
+		}
+		info, err := runtime.SplitPath(string(ev.Kv.Key))
+		if err != nil {
+			return runtime.Result{}, false
+		}
+		return runtime.Result{Action: registry.ActionDelete, Data: &info}, true
+	}
+	return runtime.Result{}, false
+}
@@ -0,0 +1,187 @@
+package memory
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/config"
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+)
+
+func newDesc(name, version, addr string) *runtime.ServiceDesc {
+	return &runtime.ServiceDesc{
+		ID:       name + "@" + addr,
+		Name:     name,
+		Version:  version,
+		EndPoint: *config.NewEndpoint(addr),
+	}
+}
+
+func TestMemoryRegistry(t *testing.T) {
+	t.Run("RegisterAndWatch", func(t *testing.T) {
+		Reset()
+		first := NewMemoryRegistry()
+		require.NoError(t, first.Register(newDesc("greeter", "v1", "127.0.0.1:9001")))
+
+		w, err := first.Watch("greeter")
+		require.NoError(t, err)
+		defer w.Stop()
+
+		result, err := w.Next()
+		require.NoError(t, err)
+		require.Equal(t, registry.ActionCreate, result.Action)
+
+		second := NewMemoryRegistry()
+		require.NoError(t, second.Register(newDesc("greeter", "v2", "127.0.0.1:9002")))
+		result, err = w.Next()
+		require.NoError(t, err)
+		require.Equal(t, registry.ActionCreate, result.Action)
+		require.Equal(t, "v2", result.Data.(*runtime.ServiceDesc).Version)
+
+		svcs, err := first.GetServices()
+		require.NoError(t, err)
+		require.Len(t, svcs, 2)
+
+		require.NoError(t, second.Unregister())
+		result, err = w.Next()
+		require.NoError(t, err)
+		require.Equal(t, registry.ActionDelete, result.Action)
+		require.Equal(t, "127.0.0.1:9002", result.Data.(*runtime.ServiceDesc).Addr)
+
+		w.Stop()
+		_, err = w.Next()
+		require.ErrorIs(t, err, registry.ErrWatcherStopped)
+	})
+
+	t.Run("ListServices", func(t *testing.T) {
+		Reset()
+		greeter := newDesc("greeter", "v1", "127.0.0.1:9001")
+		greeter.Tags = []string{"canary"}
+		greeter.Metadata = map[string]string{"zone": "a"}
+		defaultStore.put(greeter, 0)
+		defaultStore.put(newDesc("greeter", "v2", "127.0.0.1:9002"), 0)
+		defaultStore.put(newDesc("orders", "v1", "127.0.0.1:9101"), 0)
+
+		reg := NewMemoryRegistry()
+		svcs, err := reg.ListServices()
+		require.NoError(t, err)
+		require.Len(t, svcs, 3)
+		require.Equal(t, []string{"canary"}, svcs[0].Tags)
+		require.Equal(t, "a", svcs[0].Metadata["zone"])
+
+		svcs, err = reg.GetService("greeter", "v2")
+		require.NoError(t, err)
+		require.Len(t, svcs, 1)
+		require.Equal(t, "127.0.0.1:9002", svcs[0].Addr)
+
+		svcs, err = reg.GetService("greeter", "")
+		require.NoError(t, err)
+		require.Len(t, svcs, 2)
+	})
+
+	t.Run("Expire", func(t *testing.T) {
+		Reset()
+		w := defaultStore.watch("greeter")
+		defer w.Stop()
+
+		desc := newDesc("greeter", "", "127.0.0.1:9001")
+		defaultStore.put(desc, 20*time.Millisecond)
+		desc.Weight = 10
+		defaultStore.put(desc, 20*time.Millisecond)
+
+		for _, action := range []string{registry.ActionCreate, registry.ActionUpdate, registry.ActionDelete} {
+			result, err := w.Next()
+			require.NoError(t, err)
+			require.Equal(t, action, result.Action)
+		}
+		require.Empty(t, defaultStore.list("greeter", ""))
+	})
+}
+
+func TestMemoryResolver(t *testing.T) {
+	Reset()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	reg := NewMemoryRegistry()
+	require.NoError(t, reg.Register(newDesc("greeter", "v1", lis.Addr().String())))
+	defer reg.Unregister()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, target := range []string{"memory:///greeter", "memory://v1/greeter"} {
+		conn, err := grpc.DialContext(ctx, target, grpc.WithInsecure(), grpc.WithResolvers(NewResolver()))
+		require.NoError(t, err)
+
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+		require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+		conn.Close()
+	}
+}
+
+// fakeClientConn 记录解析器更新的连接状态
+type fakeClientConn struct {
+	resolver.ClientConn
+	mu    sync.Mutex
+	state resolver.State
+}
+
+func (cc *fakeClientConn) UpdateState(state resolver.State) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.state = state
+	return nil
+}
+
+func (cc *fakeClientConn) addresses() []resolver.Address {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.state.Addresses
+}
+
+func TestMemoryResolverFilters(t *testing.T) {
+	Reset()
+
+	stable := newDesc("greeter", "v1", "127.0.0.1:9001")
+	canary := newDesc("greeter", "v2", "127.0.0.1:9002")
+	canary.Tags = []string{"canary", "zone-a"}
+	canary.Weight = 3
+	defaultStore.put(stable, 0)
+	defaultStore.put(canary, 0)
+
+	for name, opts := range map[string][]registry.ResolverOption{
+		"Version": {registry.WithVersion("v2")},
+		"Tags":    {registry.WithTags("canary", "zone-a")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cc := &fakeClientConn{}
+			r, err := NewResolver(opts...).Build(resolver.Target{Endpoint: "greeter"}, cc, resolver.BuildOptions{})
+			require.NoError(t, err)
+			defer r.Close()
+
+			addrs := cc.addresses()
+			require.Len(t, addrs, 1)
+			addr := addrs[0]
+			require.Equal(t, "127.0.0.1:9002", addr.Addr)
+			require.Equal(t, int64(3), runtime.AddressWeight(addr))
+			require.Equal(t, "v2", runtime.AddressVersion(addr))
+		})
+	}
+}
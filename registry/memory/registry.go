@@ -0,0 +1,134 @@
+// Package memory 实现基于进程内存的注册中心与 gRPC 解析器，
+// 用于单元测试及单进程部署，无需运行 etcd 或 Consul
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"go.uber.org/zap"
+)
+
+// MemoryRegistry 基于进程内存的注册中心
+type MemoryRegistry struct {
+	sync.Mutex
+	options *registry.Options
+	srvInfo *runtime.ServiceDesc
+	ttl     time.Duration
+	closeCh chan struct{}
+	down    bool // 服务不健康时从注册表中移除
+	logger  *zap.Logger
+}
+
+// NewMemoryRegistry 创建基于进程内存的注册中心，同一进程中的实例共享同一份注册表
+func NewMemoryRegistry(opts ...registry.Option) registry.Registry {
+	options := registry.DefaultOptions()
+	for _, opt := range opts {
+		opt(options)
+	}
+	return &MemoryRegistry{
+		options: options,
+		logger:  options.Logger,
+	}
+}
+
+// Register 注册服务实例，实例在 EndPoint.TTL 秒内未续期将过期删除
+func (r *MemoryRegistry) Register(srvInfo *runtime.ServiceDesc) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closeCh != nil {
+		return errors.New("服务已注册")
+	}
+
+	r.srvInfo = srvInfo
+	r.ttl = time.Duration(srvInfo.EndPoint.TTL) * time.Second
+	if !r.down {
+		defaultStore.put(srvInfo, r.ttl)
+	}
+	r.logger.Info(fmt.Sprintf("%v 服务已成功注册", srvInfo.Name))
+
+	r.closeCh = make(chan struct{})
+	if r.ttl > 0 {
+		go r.keepAlive(r.closeCh, r.ttl)
+	}
+	return nil
+}
+
+// Unregister 注销已注册的服务
+func (r *MemoryRegistry) Unregister() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closeCh == nil {
+		return errors.New("服务未注册")
+	}
+	close(r.closeCh)
+	r.closeCh = nil
+	defaultStore.remove(r.srvInfo)
+	return nil
+}
+
+// GetServices 获取与当前服务同名的所有实例
+func (r *MemoryRegistry) GetServices() ([]*runtime.ServiceDesc, error) {
+	if r.srvInfo == nil {
+		return nil, errors.New("服务未注册")
+	}
+	return defaultStore.list(r.srvInfo.Name, ""), nil
+}
+
+// ListServices 获取所有服务的全部实例
+func (r *MemoryRegistry) ListServices() ([]*runtime.ServiceDesc, error) {
+	return defaultStore.all(), nil
+}
+
+// GetService 获取指定服务的实例，version 为空时返回所有版本
+func (r *MemoryRegistry) GetService(name, version string) ([]*runtime.ServiceDesc, error) {
+	return defaultStore.list(name, version), nil
+}
+
+// Watch 监视指定服务所有版本的实例变更
+func (r *MemoryRegistry) Watch(serviceName string) (runtime.Watcher, error) {
+	return defaultStore.watch(serviceName), nil
+}
+
+// UpdateHealth 服务不健康时将实例从注册表中移除，恢复后重新写入
+func (r *MemoryRegistry) UpdateHealth(serving bool) error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.down = !serving
+	if r.closeCh == nil {
+		return nil
+	}
+	if serving {
+		defaultStore.put(r.srvInfo, r.ttl)
+	} else {
+		defaultStore.remove(r.srvInfo)
+	}
+	return nil
+}
+
+// keepAlive 每隔半个TTL为实例续期
+func (r *MemoryRegistry) keepAlive(closeCh chan struct{}, ttl time.Duration) {
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closeCh:
+			return
+		case <-ticker.C:
+			r.Lock()
+			if !r.down && r.closeCh == closeCh {
+				defaultStore.put(r.srvInfo, r.ttl)
+			}
+			r.Unlock()
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"google.golang.org/grpc/resolver"
+)
+
+const schema = "memory"
+
+// MemoryResolver 基于进程内注册表的 resolver.Builder，
+// 目标地址的格式为 memory://[version]/name
+type MemoryResolver struct {
+	options *registry.ResolverOptions
+}
+
+// NewResolver 创建基于进程内注册表的解析器，可通过 grpc.WithResolvers 使用
+func NewResolver(opts ...registry.ResolverOption) *MemoryResolver {
+	return &MemoryResolver{options: registry.NewResolverOptions(opts...)}
+}
+
+// Scheme returns the scheme supported by this resolver.
+func (b *MemoryResolver) Scheme() string {
+	return schema
+}
+
+// Build creates a new resolver.Resolver for the given target
+func (b *MemoryResolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &memoryResolver{
+		cc:      cc,
+		name:    target.Endpoint,
+		version: target.Authority,
+		options: b.options,
+		watcher: defaultStore.watch(target.Endpoint),
+	}
+	r.update()
+	go r.watch()
+	return r, nil
+}
+
+type memoryResolver struct {
+	cc      resolver.ClientConn
+	name    string
+	version string
+	options *registry.ResolverOptions
+	watcher *memoryWatcher
+}
+
+// ResolveNow resolver.Resolver interface
+func (r *memoryResolver) ResolveNow(o resolver.ResolveNowOptions) {
+	r.update()
+}
+
+// Close resolver.Resolver interface
+func (r *memoryResolver) Close() {
+	r.watcher.Stop()
+}
+
+// watch 每次变更后以当前的实例列表更新连接状态
+func (r *memoryResolver) watch() {
+	for {
+		if _, err := r.watcher.Next(); err != nil {
+			return
+		}
+		r.update()
+	}
+}
+
+func (r *memoryResolver) update() {
+	addrs := make([]resolver.Address, 0)
+	for _, info := range defaultStore.list(r.name, r.version) {
+		if r.options.Match(info) {
+			addrs = append(addrs, runtime.NewAddress(info))
+		}
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+)
+
+// defaultStore 进程内共享的注册表，同一进程中的注册中心与解析器均使用它
+var defaultStore = newStore()
+
+// Reset 清空进程内的注册表，用于测试之间的隔离
+func Reset() {
+	defaultStore.reset()
+}
+
+type record struct {
+	desc  *runtime.ServiceDesc
+	timer *time.Timer
+}
+
+type store struct {
+	sync.Mutex
+	records  map[string]*record
+	watchers map[*memoryWatcher]struct{}
+}
+
+func newStore() *store {
+	return &store{
+		records:  make(map[string]*record),
+		watchers: make(map[*memoryWatcher]struct{}),
+	}
+}
+
+// put 写入或续期实例，ttl 小于等于0时实例不会过期
+func (s *store) put(desc *runtime.ServiceDesc, ttl time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	key := runtime.BuildRegPath(desc)
+	old, exists := s.records[key]
+	if exists && old.timer != nil {
+		old.timer.Stop()
+	}
+
+	rec := &record{desc: clone(desc)}
+	if ttl > 0 {
+		rec.timer = time.AfterFunc(ttl, func() {
+			s.expire(key, rec)
+		})
+	}
+	s.records[key] = rec
+
+	switch {
+	case !exists:
+		s.notify(registry.ActionCreate, rec.desc)
+	case !reflect.DeepEqual(old.desc, rec.desc):
+		// 续期不会改变实例信息，无需通知
+		s.notify(registry.ActionUpdate, rec.desc)
+	}
+}
+
+// remove 删除实例，实例不存在时返回 false
+func (s *store) remove(desc *runtime.ServiceDesc) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	key := runtime.BuildRegPath(desc)
+	rec, ok := s.records[key]
+	if !ok {
+		return false
+	}
+	if rec.timer != nil {
+		rec.timer.Stop()
+	}
+	delete(s.records, key)
+	s.notify(registry.ActionDelete, rec.desc)
+	return true
+}
+
+// expire 删除过期的实例，实例已续期时忽略
+func (s *store) expire(key string, rec *record) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.records[key] != rec {
+		return
+	}
+	delete(s.records, key)
+	s.notify(registry.ActionDelete, rec.desc)
+}
+
+// list 返回指定服务的实例，version 为空时返回所有版本
+func (s *store) list(name, version string) []*runtime.ServiceDesc {
+	s.Lock()
+	defer s.Unlock()
+	return s.match(name, version)
+}
+
+// all 返回所有服务的实例，按注册路径排序
+func (s *store) all() []*runtime.ServiceDesc {
+	s.Lock()
+	defer s.Unlock()
+
+	keys := make([]string, 0, len(s.records))
+	for key := range s.records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	svcs := make([]*runtime.ServiceDesc, 0, len(keys))
+	for _, key := range keys {
+		svcs = append(svcs, clone(s.records[key].desc))
+	}
+	return svcs
+}
+
+// match 须在持有锁时调用
+func (s *store) match(name, version string) []*runtime.ServiceDesc {
+	svcs := make([]*runtime.ServiceDesc, 0)
+	for _, rec := range s.records {
+		if rec.desc.Name == name && (version == "" || rec.desc.Version == version) {
+			svcs = append(svcs, clone(rec.desc))
+		}
+	}
+	return svcs
+}
+
+// watch 创建监视器，现有的实例作为 create 结果首先返回
+func (s *store) watch(name string) *memoryWatcher {
+	s.Lock()
+	defer s.Unlock()
+
+	w := &memoryWatcher{
+		store:  s,
+		name:   name,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for _, desc := range s.match(name, "") {
+		w.pending = append(w.pending, runtime.Result{Action: registry.ActionCreate, Data: desc})
+	}
+	s.watchers[w] = struct{}{}
+	return w
+}
+
+func (s *store) unwatch(w *memoryWatcher) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.watchers, w)
+}
+
+// notify 须在持有锁时调用
+func (s *store) notify(action string, desc *runtime.ServiceDesc) {
+	for w := range s.watchers {
+		if w.name == desc.Name {
+			w.push(runtime.Result{Action: action, Data: clone(desc)})
+		}
+	}
+}
+
+func (s *store) reset() {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, rec := range s.records {
+		if rec.timer != nil {
+			rec.timer.Stop()
+		}
+	}
+	s.records = make(map[string]*record)
+}
+
+func clone(desc *runtime.ServiceDesc) *runtime.ServiceDesc {
+	c := *desc
+	if desc.Tags != nil {
+		c.Tags = append([]string(nil), desc.Tags...)
+	}
+	if desc.Metadata != nil {
+		c.Metadata = make(map[string]string, len(desc.Metadata))
+		for k, v := range desc.Metadata {
+			c.Metadata[k] = v
+		}
+	}
+	return &c
+}
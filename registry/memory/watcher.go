@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/runtime"
+)
+
+type memoryWatcher struct {
+	sync.Mutex
+	store   *store
+	name    string
+	pending []runtime.Result
+	notify  chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// push 由注册表在持有其锁时调用
+func (w *memoryWatcher) push(result runtime.Result) {
+	w.Lock()
+	w.pending = append(w.pending, result)
+	w.Unlock()
+
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next 阻塞直至有新的变更，监视器停止后返回 registry.ErrWatcherStopped
+func (w *memoryWatcher) Next() (runtime.Result, error) {
+	for {
+		select {
+		case <-w.done:
+			return runtime.Result{}, registry.ErrWatcherStopped
+		default:
+		}
+
+		w.Lock()
+		if len(w.pending) > 0 {
+			result := w.pending[0]
+			w.pending = w.pending[1:]
+			w.Unlock()
+			return result, nil
+		}
+		w.Unlock()
+
+		select {
+		case <-w.done:
+			return runtime.Result{}, registry.ErrWatcherStopped
+		case <-w.notify:
+		}
+	}
+}
+
+// Stop 停止监视
+func (w *memoryWatcher) Stop() {
+	w.once.Do(func() {
+		w.store.unwatch(w)
+		close(w.done)
+	})
+}
@@ -6,6 +6,8 @@ type Options struct {
 	Endpoints   []string
 	DialTimeout int
 	Logger      *zap.Logger
+	// KeyPrefix 服务键的公共前缀，为空时服务键为 /<服务名>/<版本>/<地址>
+	KeyPrefix string
 }
 
 type Option func(*Options)
@@ -22,6 +24,14 @@ func DialTimeout(timeout int) Option {
 	}
 }
 
+// WithKeyPrefix 设置服务键的公共前缀，如 "/services"，使注册中心只读写该前缀下的键。
+// 注册服务与解析服务的一方须使用相同的前缀，解析器通过 WithResolverKeyPrefix 设置
+func WithKeyPrefix(prefix string) Option {
+	return func(o *Options) {
+		o.KeyPrefix = prefix
+	}
+}
+
 func DefaultOptions() *Options {
 	return &Options{
 		Endpoints:   make([]string, 0),
@@ -1,6 +1,20 @@
 package registry
 
-import "github.com/dotnetage/go-titan/runtime"
+import (
+	"errors"
+
+	"github.com/dotnetage/go-titan/runtime"
+)
+
+// Watch 返回的变更动作，runtime.Result.Data 为变更的 *runtime.ServiceDesc
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// ErrWatcherStopped 监视器已停止
+var ErrWatcherStopped = errors.New("监视器已停止")
 
 // Registry 服务注册器
 type Registry interface {
@@ -11,6 +25,22 @@ type Registry interface {
 	// Unregister 注销已注册的服务
 	Unregister() error
 
-	// GetServices 获取已注册的服务实例的列表
+	// GetServices 获取与当前注册服务同名的所有实例
 	GetServices() ([]*runtime.ServiceDesc, error)
+
+	// ListServices 获取注册中心中所有服务的全部存活实例
+	ListServices() ([]*runtime.ServiceDesc, error)
+
+	// GetService 获取指定服务的存活实例，version 为空时返回所有版本
+	GetService(name, version string) ([]*runtime.ServiceDesc, error)
+
+	// Watch 监视指定服务的实例变更，首次调用 Next 时会先返回现有实例的 create 结果
+	Watch(serviceName string) (runtime.Watcher, error)
+}
+
+// HealthUpdater 可由注册中心实现，在服务健康状态变化时下线或恢复已注册的实例
+type HealthUpdater interface {
+	// UpdateHealth serving 为 false 时实例不再被发现，为 true 时重新上线。
+	// 在 Register 之前调用时，实例将以对应的状态注册
+	UpdateHealth(serving bool) error
 }
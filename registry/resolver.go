@@ -0,0 +1,75 @@
+package registry
+
+import "github.com/dotnetage/go-titan/runtime"
+
+// Filter 判断解析器是否保留服务实例
+type Filter func(info *runtime.ServiceDesc) bool
+
+// ResolverOptions 解析器选项
+type ResolverOptions struct {
+	Filters []Filter
+	// KeyPrefix 服务键的公共前缀，须与注册中心的 WithKeyPrefix 一致
+	KeyPrefix string
+}
+
+type ResolverOption func(*ResolverOptions)
+
+// WithFilter 只保留满足条件的实例
+func WithFilter(filter Filter) ResolverOption {
+	return func(o *ResolverOptions) {
+		o.Filters = append(o.Filters, filter)
+	}
+}
+
+// WithVersion 只保留指定版本的实例，可用于灰度发布
+func WithVersion(version string) ResolverOption {
+	return WithFilter(func(info *runtime.ServiceDesc) bool {
+		return info.Version == version
+	})
+}
+
+// WithTags 只保留包含全部指定标签的实例
+func WithTags(tags ...string) ResolverOption {
+	return WithFilter(func(info *runtime.ServiceDesc) bool {
+		for _, tag := range tags {
+			if !hasTag(info.Tags, tag) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// WithResolverKeyPrefix 设置解析器查询的服务键前缀
+func WithResolverKeyPrefix(prefix string) ResolverOption {
+	return func(o *ResolverOptions) {
+		o.KeyPrefix = prefix
+	}
+}
+
+func NewResolverOptions(opts ...ResolverOption) *ResolverOptions {
+	options := &ResolverOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+	return options
+}
+
+// Match 判断实例是否满足全部过滤条件
+func (o *ResolverOptions) Match(info *runtime.ServiceDesc) bool {
+	for _, filter := range o.Filters {
+		if !filter(info) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -140,3 +140,9 @@ func (r *gormRepos) Clear(models ...interface{}) error {
 func (r *gormRepos) DB() interface{} {
 	return r.db
 }
+
+func (r *gormRepos) Transaction(fn func(tx Repository) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&gormRepos{db: tx})
+	})
+}
@@ -32,6 +32,8 @@ type Repository interface {
 	Clear(model ...interface{}) error
 	// DB 返回仓库使用的数据库实例
 	DB() interface{}
+	// Transaction 在同一个数据库事务内执行fn，fn返回错误时整个事务回滚
+	Transaction(fn func(tx Repository) error) error
 }
 
 //DataWorks 统一数据存储对象(UnitOfWorks)
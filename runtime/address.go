@@ -0,0 +1,64 @@
+package runtime
+
+import (
+	"google.golang.org/grpc/resolver"
+)
+
+// attributeKey resolver.Address 属性的键
+type attributeKey string
+
+const (
+	weightKey  attributeKey = "weight"
+	versionKey attributeKey = "version"
+	tagsKey    attributeKey = "tags"
+)
+
+// Tags 服务实例的标签，实现了 Equal 以便作为 resolver.Address 的属性进行比较
+type Tags []string
+
+// Equal 比较两组标签是否相同
+func (t Tags) Equal(o interface{}) bool {
+	other, ok := o.(Tags)
+	if !ok || len(t) != len(other) {
+		return false
+	}
+	for i := range t {
+		if t[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// NewAddress 将服务实例转换为 resolver.Address，权重、版本及标签保存在 Attributes 中
+func NewAddress(info *ServiceDesc) resolver.Address {
+	addr := resolver.Address{Addr: info.Addr}
+	addr.Attributes = addr.Attributes.
+		WithValue(weightKey, info.Weight).
+		WithValue(versionKey, info.Version).
+		WithValue(tagsKey, Tags(info.Tags))
+	return addr
+}
+
+// AddressWeight 获取地址的权重，兼容保存在 Metadata 中的权重
+func AddressWeight(addr resolver.Address) int64 {
+	if w, ok := addr.Attributes.Value(weightKey).(int64); ok {
+		return w
+	}
+	if w, ok := addr.Metadata.(int64); ok {
+		return w
+	}
+	return 0
+}
+
+// AddressVersion 获取地址对应的服务版本
+func AddressVersion(addr resolver.Address) string {
+	v, _ := addr.Attributes.Value(versionKey).(string)
+	return v
+}
+
+// AddressTags 获取地址对应的服务标签
+func AddressTags(addr resolver.Address) []string {
+	tags, _ := addr.Attributes.Value(tagsKey).(Tags)
+	return tags
+}
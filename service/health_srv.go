@@ -1,25 +1,127 @@
 package service
 
 import (
-	"context"
+	"sync"
 
-	health "google.golang.org/grpc/health/grpc_health_v1"
+	"github.com/dotnetage/go-titan/registry"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
-type healthServer struct {
-	health.HealthServer
+// NewHealthServer 创建gRPC健康检查服务，初始状态为 SERVING
+func NewHealthServer() healthpb.HealthServer {
+	return health.NewServer()
+}
+
+// HealthManager 管理gRPC服务的健康状态。
+//
+// 服务名为空表示整个服务实例，只有全部服务均为 SERVING 时实例才是健康的，
+// 实例的健康状态变化后会通知实现了 registry.HealthUpdater 的注册中心，
+// 使实例在注册中心中下线或恢复
+type HealthManager struct {
+	mu       sync.Mutex
+	server   *health.Server
+	statuses map[string]healthpb.HealthCheckResponse_ServingStatus
+	serving  bool
+	shutdown bool
+	registry registry.Registry
+	logger   *zap.Logger
+}
+
+// NewHealthManager 创建健康状态管理器，reg 可以为 nil
+func NewHealthManager(reg registry.Registry, logger *zap.Logger) *HealthManager {
+	return &HealthManager{
+		server:   health.NewServer(),
+		statuses: map[string]healthpb.HealthCheckResponse_ServingStatus{"": healthpb.HealthCheckResponse_SERVING},
+		serving:  true,
+		registry: reg,
+		logger:   logger,
+	}
 }
 
-func NewHealthServer() health.HealthServer {
-	return &healthServer{}
+// Server 获取gRPC健康检查服务
+func (m *HealthManager) Server() healthpb.HealthServer {
+	return m.server
 }
-func (s *healthServer) Check(_ context.Context, _ *health.HealthCheckRequest) (*health.HealthCheckResponse, error) {
-	return &health.HealthCheckResponse{Status: health.HealthCheckResponse_SERVING}, nil
+
+// SetServingStatus 设置指定gRPC服务的健康状态，service 为空时设置整个服务实例的状态
+func (m *HealthManager) SetServingStatus(service string, status healthpb.HealthCheckResponse_ServingStatus) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.shutdown {
+		return nil
+	}
+	m.statuses[service] = status
+	if service != "" {
+		m.server.SetServingStatus(service, status)
+	}
+
+	serving := true
+	for _, s := range m.statuses {
+		if s != healthpb.HealthCheckResponse_SERVING {
+			serving = false
+			break
+		}
+	}
+
+	// 健康检查服务中的整体状态反映全部服务，Consul 等注册中心据此进行检查
+	overall := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		overall = healthpb.HealthCheckResponse_SERVING
+	}
+	m.server.SetServingStatus("", overall)
+
+	return m.update(serving)
 }
 
-func (s *healthServer) Watch(in *health.HealthCheckRequest, server health.Health_WatchServer) error {
-	// Example of how to register both methods but only implement the Check method.
-	return server.Send(&health.HealthCheckResponse{
-		Status: health.HealthCheckResponse_SERVING,
-	})
+// update 实例的健康状态变化时通知注册中心，须在持有锁时调用。
+// 通知失败时状态保持不变，下次设置状态时重试
+func (m *HealthManager) update(serving bool) error {
+	if serving == m.serving {
+		return nil
+	}
+
+	if updater, ok := m.registry.(registry.HealthUpdater); ok {
+		if err := updater.UpdateHealth(serving); err != nil {
+			m.logger.Error("更新注册中心中的服务状态失败", zap.Error(err))
+			return err
+		}
+	}
+	m.serving = serving
+	return nil
+}
+
+// Status 获取指定gRPC服务的健康状态，未设置过的服务返回 SERVICE_UNKNOWN
+func (m *HealthManager) Status(service string) healthpb.HealthCheckResponse_ServingStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if status, ok := m.statuses[service]; ok {
+		return status
+	}
+	return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+}
+
+// Serving 服务实例是否健康
+func (m *HealthManager) Serving() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.serving
+}
+
+// Shutdown 将全部服务置为 NOT_SERVING 且不再接受状态变更，用于优雅关机。
+// 实例随即在注册中心中下线，客户端不再向其发送新的请求
+func (m *HealthManager) Shutdown() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.shutdown = true
+	m.server.Shutdown()
+	for service := range m.statuses {
+		m.statuses[service] = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return m.update(false)
 }
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dotnetage/go-titan/config"
+	"github.com/dotnetage/go-titan/registry"
+	"github.com/dotnetage/go-titan/registry/memory"
+	"github.com/dotnetage/go-titan/runtime"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthManager(t *testing.T) {
+	memory.Reset()
+	ctx := context.Background()
+
+	reg := memory.NewMemoryRegistry()
+	m := NewHealthManager(reg, zap.NewNop())
+	require.NoError(t, m.SetServingStatus("orders.Orders", healthpb.HealthCheckResponse_SERVING))
+	require.NoError(t, m.SetServingStatus("orders.Reports", healthpb.HealthCheckResponse_SERVING))
+	require.NoError(t, reg.Register(&runtime.ServiceDesc{ID: "orders-1", Name: "orders", EndPoint: *config.NewEndpoint("127.0.0.1:9001")}))
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := m.Server().Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	instances := func() int {
+		svcs, err := reg.GetService("orders", "")
+		require.NoError(t, err)
+		return len(svcs)
+	}
+	require.Equal(t, 1, instances())
+
+	// 单个服务进入维护状态时实例整体不健康并从注册中心下线
+	require.NoError(t, m.SetServingStatus("orders.Reports", healthpb.HealthCheckResponse_NOT_SERVING))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check("orders.Orders"))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check("orders.Reports"))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.False(t, m.Serving())
+	require.Equal(t, 0, instances())
+
+	require.NoError(t, m.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING))
+	require.NoError(t, m.SetServingStatus("orders.Reports", healthpb.HealthCheckResponse_SERVING))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, 0, instances())
+
+	require.NoError(t, m.SetServingStatus("", healthpb.HealthCheckResponse_SERVING))
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	require.True(t, m.Serving())
+	require.Equal(t, 1, instances())
+
+	// 关机时实例先从注册中心下线，之后的状态变更被忽略
+	require.NoError(t, m.Shutdown())
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, m.Status("orders.Orders"))
+	require.False(t, m.Serving())
+	require.Equal(t, 0, instances())
+
+	require.NoError(t, m.SetServingStatus("", healthpb.HealthCheckResponse_SERVING))
+	require.False(t, m.Serving())
+	require.Equal(t, 0, instances())
+
+	require.NoError(t, reg.Unregister())
+}
+
+// flakyRegistry 第一次更新健康状态时返回错误
+type flakyRegistry struct {
+	registry.Registry
+	calls []bool
+}
+
+func (r *flakyRegistry) UpdateHealth(serving bool) error {
+	r.calls = append(r.calls, serving)
+	if len(r.calls) == 1 {
+		return errors.New("registry unavailable")
+	}
+	return nil
+}
+
+func TestHealthManagerRetry(t *testing.T) {
+	reg := &flakyRegistry{}
+	m := NewHealthManager(reg, zap.NewNop())
+
+	// 注册中心更新失败后状态不变，再次设置相同状态时重试
+	require.Error(t, m.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING))
+	require.True(t, m.Serving())
+	require.NoError(t, m.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING))
+	require.False(t, m.Serving())
+	require.Equal(t, []bool{false, false}, reg.calls)
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	auth "github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/broker"
 	"github.com/dotnetage/go-titan/config"
 	"github.com/dotnetage/go-titan/registry"
 	"github.com/dotnetage/go-titan/runtime"
@@ -23,6 +24,7 @@ type Options struct {
 	HealthCheck      bool                 // 是否启用健康度检查
 	Auth             auth.Tokens          // 身份验证组件
 	Registry         registry.Registry    // 注册中心
+	Broker           broker.Broker        // 消息代理
 	Config           *config.ServerConfig // 配置中心
 	Logger           *zap.Logger          // 日志
 }
@@ -87,6 +89,13 @@ func Registry(registry registry.Registry) Option {
 	}
 }
 
+// Broker 设置用于事件订阅的消息代理
+func Broker(b broker.Broker) Option {
+	return func(o *Options) {
+		o.Broker = b
+	}
+}
+
 func Logger(logger *zap.Logger) Option {
 	return func(o *Options) {
 		o.Logger = logger
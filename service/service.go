@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/dotnetage/go-titan/auth"
+	"github.com/dotnetage/go-titan/broker"
 
 	health "google.golang.org/grpc/health/grpc_health_v1"
 
@@ -37,6 +39,12 @@ type (
 		// Server 获取内置的gRPC服务器实例
 		Server() *grpc.Server
 
+		// Health 获取健康状态管理器，可在维护期间将指定的gRPC服务置为 NOT_SERVING
+		Health() *HealthManager
+
+		// Subscribe 订阅消息代理中的主题，订阅会在服务启动后建立并在关闭服务时注销
+		Subscribe(topic string, handler broker.Handler, opts ...broker.SubscribeOption) MicroService
+
 		// Start 启动微服务
 		Start()
 	}
@@ -47,6 +55,16 @@ type (
 		server              *grpc.Server
 		rpcServiceDescs     []*grpc.ServiceDesc
 		rpcServiceInstances []interface{}
+		subscriptions       []*subscription
+		subscribers         []broker.Subscriber
+		health              *HealthManager
+	}
+
+	// subscription 等待服务启动后建立的事件订阅
+	subscription struct {
+		topic   string
+		handler broker.Handler
+		opts    []broker.SubscribeOption
 	}
 )
 
@@ -57,6 +75,7 @@ func New(options ...Option) MicroService {
 		rpcServiceInstances: make([]interface{}, 0),
 	}
 	b.logger = b.options.Logger
+	b.health = NewHealthManager(b.options.Registry, b.logger)
 	return b
 }
 
@@ -68,6 +87,10 @@ func (b *microService) Server() *grpc.Server {
 	return b.server
 }
 
+func (b *microService) Health() *HealthManager {
+	return b.health
+}
+
 func (b *microService) WaitForClose() {
 
 	// 优雅地关机
@@ -79,12 +102,8 @@ func (b *microService) WaitForClose() {
 		switch s {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
 			b.logger.Sugar().Infof("正在尝试关闭%s服务...", b.options.ServiceDesc.Name)
-			b.server.GracefulStop()
-
-			if b.options.Registry != nil {
-				if err := b.options.Registry.Unregister(); err != nil {
-					b.logger.Fatal("注销服务失败", zap.Error(err))
-				}
+			if err := b.stop(); err != nil {
+				b.logger.Fatal("注销服务失败", zap.Error(err))
 			}
 			time.Sleep(time.Second)
 			b.logger.Info("验证服务已下线")
@@ -107,46 +126,132 @@ func (b *microService) Register(registerDesc *grpc.ServiceDesc, srv interface{})
 	return b
 }
 
+func (b *microService) Subscribe(topic string, handler broker.Handler, opts ...broker.SubscribeOption) MicroService {
+	b.subscriptions = append(b.subscriptions, &subscription{
+		topic:   topic,
+		handler: handler,
+		opts:    opts,
+	})
+	return b
+}
+
 func (b *microService) Start() {
-	b.initGRPCServer()
+	if err := b.start(); err != nil {
+		b.logger.Fatal("服务启动失败", zap.Error(err))
+	}
+	b.WaitForClose()
+}
 
-	lis, err := b.options.ServiceDesc.Listen()
+// start 建立网络侦听、事件订阅并注册服务。订阅在侦听建立之后、开始处理请求之前建立，
+// 任一步骤失败时撤销已完成的步骤并返回错误
+func (b *microService) start() error {
+	if err := b.initGRPCServer(); err != nil {
+		return err
+	}
 
+	lis, err := b.options.ServiceDesc.Listen()
 	if err != nil {
-		b.logger.Fatal(fmt.Sprintf("起动网络侦听失败:%v", err))
+		return fmt.Errorf("起动网络侦听失败: %w", err)
 	}
 
 	if lis == nil {
-		b.logger.Fatal(fmt.Sprintf("端口%v初始化失败，请检查是否被点用或地址是否有效", b.options.ServiceDesc.Addr))
+		return fmt.Errorf("端口%v初始化失败，请检查是否被点用或地址是否有效", b.options.ServiceDesc.Addr)
+	}
+
+	if err := b.startSubscribers(); err != nil {
+		lis.Close()
+		return err
 	}
 
 	go func() {
 		services := b.server.GetServiceInfo()
-		for k, _ := range services {
+		for k := range services {
 			b.logger.Info(fmt.Sprintf("启用%v服务", k))
 		}
-		b.logger.Info(fmt.Sprintf("验证服务已成功上线: %s", b.options.ServiceDesc.EndPoint.Addr))
-		err = b.server.Serve(lis)
-		if err != nil {
+		b.logger.Info(fmt.Sprintf("验证服务已成功上线: %s", lis.Addr()))
+		// 起动完成前即关机时 Serve 返回 ErrServerStopped
+		if err := b.server.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
 			panic(fmt.Sprintf("服务启动失败 : %v", err))
 		}
 	}()
 
 	if b.options.Registry != nil {
 		b.logger.Info(fmt.Sprintf("正在向注册中心注册验证服务: %s", b.options.ServiceDesc.Name))
-		if err = b.options.Registry.Register(b.options.ServiceDesc); err != nil {
-			b.logger.Fatal("服务注册组件起动失败", zap.Error(err))
+		if err := b.options.Registry.Register(b.options.ServiceDesc); err != nil {
+			b.server.Stop()
+			b.stopSubscribers()
+			return fmt.Errorf("服务注册组件起动失败: %w", err)
 		}
 	}
 
-	b.WaitForClose()
+	return nil
+}
+
+// stop 先将服务置为 NOT_SERVING 并从注册中心注销，使客户端不再发送新的请求，
+// 再等待处理中的请求完成并注销订阅
+func (b *microService) stop() error {
+	// 注册中心状态更新失败时已记录日志，仍继续注销
+	_ = b.health.Shutdown()
+
+	var err error
+	if b.options.Registry != nil {
+		err = b.options.Registry.Unregister()
+	}
+
+	b.server.GracefulStop()
+	b.stopSubscribers()
+	return err
+}
+
+// startSubscribers 连接消息代理并建立已登记的订阅，失败时注销已建立的订阅
+func (b *microService) startSubscribers() error {
+	if len(b.subscriptions) == 0 {
+		return nil
+	}
+
+	if b.options.Broker == nil {
+		return errors.New("已登记事件订阅，但未设置消息代理，请使用Broker选项进行设置")
+	}
+
+	if err := b.options.Broker.Connect(); err != nil {
+		return fmt.Errorf("连接消息代理失败: %w", err)
+	}
+
+	for _, sub := range b.subscriptions {
+		subscriber, err := b.options.Broker.Subscribe(sub.topic, sub.handler, sub.opts...)
+		if err != nil {
+			b.stopSubscribers()
+			return fmt.Errorf("订阅主题%s失败: %w", sub.topic, err)
+		}
+		b.subscribers = append(b.subscribers, subscriber)
+		b.logger.Info(fmt.Sprintf("已订阅主题: %s", sub.topic))
+	}
+	return nil
+}
+
+// stopSubscribers 注销全部订阅并断开消息代理
+func (b *microService) stopSubscribers() {
+	if len(b.subscriptions) == 0 || b.options.Broker == nil {
+		return
+	}
+
+	for _, subscriber := range b.subscribers {
+		if err := subscriber.Unsubscribe(); err != nil {
+			b.logger.Error(fmt.Sprintf("注销主题%s的订阅失败", subscriber.Topic()), zap.Error(err))
+		}
+	}
+	b.subscribers = nil
+
+	if err := b.options.Broker.Disconnect(); err != nil {
+		b.logger.Error("断开消息代理失败", zap.Error(err))
+	}
 }
 
 func (b *microService) Options() *Options {
 	return b.options
 }
 
-func (b *microService) initGRPCServer() {
+func (b *microService) initGRPCServer() error {
 
 	serverOptions := []grpc.ServerOption{
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
@@ -186,14 +291,21 @@ func (b *microService) initGRPCServer() {
 		b.options.Logger.Fatal("没有任何可运行的服务，请使用Use方法先进行服务注册")
 	}
 
-	if b.options.HealthCheck {
-		health.RegisterHealthServer(b.server, NewHealthServer())
+	// 注册中心通过gRPC健康检查判断服务状态，设置注册中心时总是启用
+	if b.options.HealthCheck || b.options.Registry != nil {
+		health.RegisterHealthServer(b.server, b.health.Server())
 	}
 
 	// 注册服务器
 	for i, desc := range b.rpcServiceDescs {
 		b.server.RegisterService(desc, b.rpcServiceInstances[i])
+		if b.health.Status(desc.ServiceName) == health.HealthCheckResponse_SERVICE_UNKNOWN {
+			if err := b.health.SetServingStatus(desc.ServiceName, health.HealthCheckResponse_SERVING); err != nil {
+				return fmt.Errorf("设置%v服务健康状态失败: %w", desc.ServiceName, err)
+			}
+		}
 	}
+	return nil
 }
 
 func (b *microService) onAuth(ctx context.Context) (context.Context, error) {
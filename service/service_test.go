@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dotnetage/go-titan/broker"
+	"github.com/dotnetage/go-titan/broker/memory"
+	"github.com/dotnetage/go-titan/registry"
+	regmemory "github.com/dotnetage/go-titan/registry/memory"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// failingBroker 订阅指定主题时返回错误
+type failingBroker struct {
+	broker.Broker
+	topic string
+}
+
+func (b *failingBroker) Subscribe(topic string, h broker.Handler, opts ...broker.SubscribeOption) (broker.Subscriber, error) {
+	if topic == b.topic {
+		return nil, errors.New("subscribe refused")
+	}
+	return b.Broker.Subscribe(topic, h, opts...)
+}
+
+// recordingRegistry 记录注销时服务实例的健康状态
+type recordingRegistry struct {
+	registry.Registry
+	srv     *microService
+	serving []bool
+}
+
+func (r *recordingRegistry) Unregister() error {
+	r.serving = append(r.serving, r.srv.health.Serving())
+	return r.Registry.Unregister()
+}
+
+func newTestService(b broker.Broker) *microService {
+	srv := New(Logger(zap.NewNop()), Listen("127.0.0.1:0"), Broker(b), Reflection(false)).(*microService)
+	srv.Register(&healthpb.Health_ServiceDesc, health.NewServer())
+	return srv
+}
+
+func TestSubscriberLifecycle(t *testing.T) {
+	t.Run("StartAndStop", func(t *testing.T) {
+		b := memory.NewBroker()
+		received := make(chan broker.Event, 1)
+
+		srv := newTestService(b)
+		srv.Subscribe("orders.created", func(e broker.Event) error {
+			received <- e
+			return nil
+		})
+
+		require.NoError(t, srv.start())
+		require.NoError(t, b.Publish("orders.created", &broker.Message{Body: []byte("order")}))
+		select {
+		case e := <-received:
+			require.Equal(t, "order", string(e.Message().Body))
+		case <-time.After(2 * time.Second):
+			t.Fatal("message was not delivered")
+		}
+
+		require.NoError(t, srv.stop())
+		require.ErrorIs(t, b.Publish("orders.created", &broker.Message{}), broker.ErrNotConnected)
+	})
+
+	t.Run("DrainBeforeUnregister", func(t *testing.T) {
+		regmemory.Reset()
+		reg := &recordingRegistry{Registry: regmemory.NewMemoryRegistry()}
+		// 设置注册中心后自动注册健康检查服务
+		srv := New(Logger(zap.NewNop()), Listen("127.0.0.1:0"), Name("orders"), Registry(reg), Reflection(false)).(*microService)
+		srv.Register(&grpc.ServiceDesc{ServiceName: "orders.Orders", HandlerType: (*interface{})(nil)}, struct{}{})
+		reg.srv = srv
+
+		require.NoError(t, srv.start())
+		require.NoError(t, srv.stop())
+		require.Equal(t, []bool{false}, reg.serving)
+	})
+
+	t.Run("SubscribeFailure", func(t *testing.T) {
+		inner := memory.NewBroker()
+		var delivered int
+		srv := newTestService(&failingBroker{Broker: inner, topic: "orders.cancelled"})
+		srv.Subscribe("orders.created", func(e broker.Event) error {
+			delivered++
+			return nil
+		})
+		srv.Subscribe("orders.cancelled", func(e broker.Event) error {
+			return nil
+		})
+
+		require.EqualError(t, srv.start(), "订阅主题orders.cancelled失败: subscribe refused")
+		require.Empty(t, srv.subscribers)
+
+		// 已建立的订阅被注销，消息代理已断开
+		require.ErrorIs(t, inner.Publish("orders.created", &broker.Message{}), broker.ErrNotConnected)
+		require.Equal(t, 0, delivered)
+	})
+
+	t.Run("MissingBroker", func(t *testing.T) {
+		srv := newTestService(nil)
+		srv.Subscribe("orders.created", func(e broker.Event) error {
+			return nil
+		})
+		require.Error(t, srv.start())
+	})
+}